@@ -1,17 +1,20 @@
 package app
 
 import (
+	"context"
 	"embed"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/url"
 	"os"
 	"path"
-	"sort"
 	"strings"
 	"tbm/scraper"
+	"tbm/search"
 	"tbm/server"
+	"tbm/storage"
 	"tbm/utils/filesystem"
 	"tbm/utils/log"
 	"time"
@@ -22,6 +25,7 @@ type Application struct {
 	DataDir  string          `json:"data_dir"`
 	Mode     ApplicationMode `json:"mode"`
 	Danger   DangerOptions   `json:"danger"`
+	Storage  StorageOptions  `json:"storage"`
 
 	Build          Build  `json:"-"`
 	ConfigFileName string `json:"-"`
@@ -29,8 +33,13 @@ type Application struct {
 	Server  *server.Server   `json:"server"`
 	Scraper *scraper.Scraper `json:"scraper"`
 
-	tweets        []*scraper.CachedTweet
-	bookmarkIndex int
+	assets embed.FS
+	store  storage.Storage
+	index  *search.Index
+	tweets *TweetRepository
+	// indexDone stops saving the search index in the background, indexSaved is closed after the last save
+	indexDone  chan bool
+	indexSaved chan bool
 }
 
 type Build struct {
@@ -42,6 +51,10 @@ type DangerOptions struct {
 	RemoveBookmarks bool `json:"remove_bookmarks"`
 }
 
+type StorageOptions struct {
+	Driver string `json:"driver"`
+}
+
 type ApplicationMode string
 
 const (
@@ -49,6 +62,9 @@ const (
 	OnlineMode  ApplicationMode = "online"
 )
 
+// ShutdownTimeout limits how long pending requests and websocket clients are waited for on shutdown
+const ShutdownTimeout = 10 * time.Second
+
 func (m ApplicationMode) ToString() string {
 	return string(m)
 }
@@ -61,15 +77,27 @@ func NewApplication(assets embed.FS) *Application {
 		DataDir:        path.Join(dir, "data"),
 		ConfigFileName: path.Join(dir, "config.json"),
 		Scraper:        scraper.NewScraper(),
-		tweets:         make([]*scraper.CachedTweet, 0),
-		bookmarkIndex:  1000000,
+		tweets:         NewTweetRepository(),
+		assets:         assets,
 		Mode:           OnlineMode,
 		Danger: DangerOptions{
 			RemoveBookmarks: false,
 		},
+		Storage: StorageOptions{
+			Driver: storage.SqliteDriver,
+		},
 	}
 	a.Server = server.NewServer(a.websocketCallback, assets)
+	a.Server.OnLoadTweet = a.loadTweet
+	a.Server.OnApiRequest = a.apiCallback
+	a.Server.OnExport = a.exportCallback
 	a.Scraper.OnNewTweet = a.onNewTweet
+	a.Scraper.OnProgress = a.onScraperProgress
+	a.Scraper.OnError = a.onScraperError
+	a.Scraper.OnRateLimit = a.onRateLimit
+	// Archived tweets don't stop an incremental sync, they are turned into bookmarks once they get fetched
+	a.Scraper.Known = a.tweets.HasBookmark
+	a.tweets.OnAdd(a.onTweetAdded)
 
 	return a
 }
@@ -89,6 +117,16 @@ func (a *Application) loadConfigFile() error {
 		if a.Scraper.RawDelay != "" {
 			a.Scraper.Delay, err = time.ParseDuration(a.Scraper.RawDelay)
 		}
+		if a.Scraper.RawInterval != "" {
+			if a.Scraper.Interval, err = time.ParseDuration(a.Scraper.RawInterval); err != nil {
+				return fmt.Errorf("invalid sync interval: %s", err.Error())
+			}
+		}
+		if a.Server.Auth.RawSessionTimeout != "" {
+			if a.Server.Auth.SessionTimeout, err = time.ParseDuration(a.Server.Auth.RawSessionTimeout); err != nil {
+				return fmt.Errorf("invalid session timeout: %s", err.Error())
+			}
+		}
 	}
 	return nil
 }
@@ -100,47 +138,160 @@ func (a *Application) Load() error {
 	filesystem.CreateDirectory(a.DataDir)
 	filesystem.CreateDirectory(path.Join(a.DataDir, "media"))
 	a.Server.Load(path.Join(a.DataDir, "media"))
-	a.LoadTweetCache()
+	if err := a.Server.TLS.Prepare(path.Join(a.DataDir, "tls"), a.Server.Host); err != nil {
+		return err
+	}
+
+	a.Scraper.StateFile = path.Join(a.DataDir, scraper.SyncStateFile)
+	if err := a.Scraper.Load(); err != nil {
+		return err
+	}
+
+	store, err := storage.Open(a.Storage.Driver, a.DataDir)
+	if err != nil {
+		return err
+	}
+	a.store = store
+
+	if a.Storage.Driver != storage.JsonDriver {
+		if err := a.MigrateJsonStorage(); err != nil {
+			return err
+		}
+	}
 
+	if err := a.LoadTweetCache(); err != nil {
+		return err
+	}
+
+	a.indexDone, a.indexSaved = make(chan bool), make(chan bool)
+	go a.saveSearchIndex()
 	return nil
 }
 
-func (a *Application) LoadTweetCache() {
-	items, _ := ioutil.ReadDir(a.DataDir)
-	tweets := make([]*scraper.CachedTweet, 0)
-	for _, item := range items {
-		if item.IsDir() == false {
-			dat, err := os.ReadFile(path.Join(a.DataDir, item.Name()))
-			if err == nil {
-				ct := &scraper.CachedTweet{}
-				if err := json.Unmarshal(dat, ct); err == nil {
-					if ct.Index != 0 && a.bookmarkIndex > ct.Index {
-						a.bookmarkIndex = ct.Index
-					} else if ct.Index == 0 {
-						ct.Index = a.bookmarkIndex - 1
-						a.bookmarkIndex = ct.Index
-					}
-					tweets = append(tweets, ct)
-				}
-			}
-		}
+//
+// MigrateJsonStorage
+// @Description: Import all tweets of a previous json data dir. An interrupted migration is continued on the next
+// start, a completed one is marked by storage.MigrationFile.
+// @receiver a *Application
+// @return error
+func (a *Application) MigrateJsonStorage() error {
+	marker := path.Join(a.DataDir, storage.MigrationFile)
+	if filesystem.Exist(marker) {
+		return nil
 	}
-	sort.Slice(tweets, func(i, j int) bool {
-		t1, _ := time.Parse("Mon Jan 02 15:04:05 -0700 2006", tweets[i].Tweet.CreatedAt)
-		t2, _ := time.Parse("Mon Jan 02 15:04:05 -0700 2006", tweets[j].Tweet.CreatedAt)
-		return t1.Before(t2)
-		// return tweets[i].Index < tweets[j].Index
-	})
-	a.tweets = tweets
+
+	src := storage.NewJsonStorage(a.DataDir)
+	if count, err := src.Count(); err != nil || count == 0 {
+		return nil
+	}
+
+	log.Info("Migrating json files from %s into the %s storage", a.DataDir, a.Storage.Driver)
+	imported, err := storage.Migrate(src, a.store)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(marker, []byte(time.Now().Format(time.RFC3339)+"\n")); err != nil {
+		return err
+	}
+	log.Statistic("%d tweets migrated. The old json files are no longer required and can be removed.", imported)
+
+	return nil
 }
 
-func (a *Application) Start() error {
+func (a *Application) LoadTweetCache() error {
+	tweets, err := a.store.All()
+	if err != nil {
+		return err
+	}
+	a.tweets.Load(tweets)
+
+	return a.LoadSearchIndex()
+}
+
+func (a *Application) loadTweet(id string) (*scraper.CachedTweet, error) {
+	return a.store.Get(id)
+}
+
+//
+// Start
+// @Description: Start the scraper and the server and block until the context is done or the server failed
+// @receiver a *Application
+// @param ctx context.Context
+// @return error
+func (a *Application) Start(ctx context.Context) error {
 	a.Server.AddState("mode", a.Mode)
+	a.Server.AddState("auth", a.Server.Auth.Enabled())
 
 	if a.Mode == OnlineMode {
-		a.Scraper.Start(a.Danger.RemoveBookmarks)
+		a.Scraper.Start(ctx, a.Danger.RemoveBookmarks)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- a.Server.Start()
+	}()
+
+	select {
+	case err := <-errs:
+		_ = a.Shutdown()
+		return err
+	case <-ctx.Done():
 	}
-	return a.Server.Start()
+
+	err := a.Shutdown()
+	if e := <-errs; e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+//
+// Sync
+// @Description: Sync the bookmarks without starting the server. Runs a single time if once is set, otherwise
+// every scraper interval until the context is done.
+// @receiver a *Application
+// @param ctx context.Context
+// @param once bool
+// @return *scraper.RunResult result of the single run, nil if the run has been interrupted or once isn't set
+// @return error
+func (a *Application) Sync(ctx context.Context, once bool) (*scraper.RunResult, error) {
+	if a.Mode == OfflineMode {
+		return nil, errors.New("bookmarks can't be synced in offline mode")
+	}
+	if once {
+		return a.Scraper.RunOnce(ctx, a.Danger.RemoveBookmarks)
+	}
+
+	a.Scraper.Start(ctx, a.Danger.RemoveBookmarks)
+	<-ctx.Done()
+	return nil, nil
+}
+
+//
+// Shutdown
+// @Description: Stop the scraper, wait for pending downloads, disconnect all clients, save the search index and close
+// the storage
+// @receiver a *Application
+// @return error
+func (a *Application) Shutdown() error {
+	log.Info("Shutting down..")
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+
+	a.Scraper.Stop()
+	err := a.Server.Shutdown(ctx)
+	if a.indexDone != nil {
+		close(a.indexDone)
+		<-a.indexSaved
+		a.indexDone = nil
+	}
+	if e := a.store.Close(); e != nil && err == nil {
+		err = e
+	}
+	if err == nil {
+		log.Success("Shutdown complete")
+	}
+	return err
 }
 
 func (a *Application) websocketCallback(m *server.Message) {
@@ -149,14 +300,15 @@ func (a *Application) websocketCallback(m *server.Message) {
 	if err := json.Unmarshal(m.Content, t); err != nil {
 		r.SetErrorStr("failed to decode message")
 	}
+	r.ID = t.ID
 
-	switch t.Command {
-	case "get_tweets":
-		r.Data["tweets"] = a.tweets
-	case "search_tweets":
-		a.searchTweets(t, r)
+	switch {
+	case t.Version > ProtocolVersion:
+		r.SetErrorStr(fmt.Sprintf("unsupported protocol version %d (supported up to %d)", t.Version, ProtocolVersion))
+	case t.Command == "subscribe" || t.Command == "unsubscribe":
+		a.subscribe(t, r, m.Client)
 	default:
-		r.SetErrorStr("unknown command")
+		a.handleTask(t, r)
 	}
 
 	if b, err := r.Encode(); err == nil {
@@ -166,139 +318,178 @@ func (a *Application) websocketCallback(m *server.Message) {
 	}
 }
 
-func (a *Application) searchTweets(t *Task, r *Response) {
-	if _query, ok := t.Payload["query"]; ok {
-		query := strings.ToLower(_query.(string))
-		tweets := make([]*scraper.CachedTweet, 0)
-		for _, tweet := range a.tweets {
-			add := strings.Contains(strings.ToLower(tweet.Tweet.FullText), query)
-			if add == false {
-				for _, u := range tweet.Tweet.Entities.Urls {
-					if strings.Contains(strings.ToLower(u.ExpandedUrl), query) {
-						add = true
-						break
-					}
-				}
+func (a *Application) onNewTweet(ctx context.Context, ct *scraper.CachedTweet) scraper.TweetStatus {
+	if a.isStored(ct) {
+		log.Info("Tweet skipped (already fetched): %s posted on %s", ct.Tweet.IdStr, ct.Tweet.CreatedAt)
+		return scraper.TweetSkipped
+	}
 
-				if add == false {
-					if strings.Contains(strings.ToLower(tweet.User.Legacy.ScreenName), query) {
-						add = true
-					} else if strings.Contains(strings.ToLower(tweet.User.Legacy.Name), query) {
-						add = true
-					}
-				}
-			}
+	conversation, err := a.Scraper.TweetDetail(ctx, ct.Tweet.IdStr)
+	if err != nil {
+		log.Error("Failed to fetch conversation %s: %s", ct.Tweet.IdStr, err.Error())
+		return scraper.TweetFailed
+	}
+	ct.Conversation = *conversation
 
-			if add {
-				tweets = append(tweets, tweet)
-			}
-		}
-		r.Data["tweets"] = tweets
-	} else {
-		r.SetErrorStr("query parameter not found")
+	if err := a.storeTweet(ctx, ct, a.Scraper.Download); err != nil {
+		log.Error("Failed to save tweet data: %s", err.Error())
+		return scraper.TweetFailed
 	}
-}
+	log.Success("New tweet fetched: %s posted on %s", ct.Tweet.IdStr, ct.Tweet.CreatedAt)
 
-func (a *Application) onNewTweet(ct *scraper.CachedTweet) bool {
-	filename := path.Join(a.DataDir, ct.Tweet.IdStr+".json")
-	if filesystem.Exist(filename) == false {
-		conversation, err := a.Scraper.TweetDetail(ct.Tweet.IdStr)
+	if a.Danger.RemoveBookmarks {
+		r, err := a.Scraper.DeleteBookmarkDetail(ctx, ct.Tweet.IdStr)
 		if err != nil {
-			log.Error("Failed to fetch conversation %s: %s", ct.Tweet.IdStr, err.Error())
-			return false
+			log.Error("Failed to remove remote bookmark %s: %s", ct.Tweet.IdStr, err.Error())
+		} else if r.Data.TweetBookmarkDelete != "Done" {
+			log.Info("Bookmark %s was already removed", ct.Tweet.IdStr)
+		} else {
+			log.Success("Bookmark removed: %s posted on %s", ct.Tweet.IdStr, ct.Tweet.CreatedAt)
+			return scraper.TweetRemoved
 		}
+	}
 
-		a.bookmarkIndex--
-		ct.Conversation = *conversation
-		ct.Index = a.bookmarkIndex
+	return scraper.TweetNew
+}
 
-		d, err := json.Marshal(ct)
-		if err == nil {
-			err = ioutil.WriteFile(filename, d, 0644)
-			if err == nil {
-				a.tweets = append(a.tweets, ct)
+// MediaFile is a remote file referenced by a tweet and the local file it gets stored in
+type MediaFile struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
 
-				ext, _ := GetFileExtensionFromUrl(ct.User.Legacy.ProfileImageUrlHttps)
-				if ext == "" {
-					ext = "blob"
-				}
-				userImageFilename := path.Join(a.DataDir, "media", ct.User.RestId+"."+ext)
-
-				_ = a.Scraper.Download(ct.User.Legacy.ProfileImageUrlHttps, userImageFilename)
-
-				for _, tweet := range conversation.GlobalObjects.Tweets {
-					for _, ctm := range tweet.ExtendedEntities.Media {
-						ext, _ = GetFileExtensionFromUrl(ctm.MediaUrlHttps)
-						if ext == "" {
-							ext = "blob"
-						}
-						mediaImageFilename := path.Join(a.DataDir, "media", ctm.IdStr+"."+ext)
-
-						_ = a.Scraper.Download(ctm.MediaUrlHttps, mediaImageFilename)
-
-						if ctm.Type == "video" {
-							maxBitrate := 0
-							videoUrl := ""
-							for _, variant := range ctm.VideoInfo.Variants {
-								if variant.Bitrate > maxBitrate {
-									videoUrl = strings.TrimSuffix(variant.Url, "?tag=10")
-									maxBitrate = variant.Bitrate
-								}
-							}
-
-							if videoUrl != "" {
-								ext, _ = GetFileExtensionFromUrl(videoUrl)
-								if ext == "" {
-									ext = "blob"
-								}
-								mediaVideoFilename := path.Join(a.DataDir, "media", ctm.IdStr+"."+ext)
-
-								_ = a.Scraper.Download(videoUrl, mediaVideoFilename)
-							}
-						}
-					}
-				}
+// isStored checks whether a tweet doesn't have to be stored again. Tweets imported from an archive are replaced once
+// they get bookmarked.
+func (a *Application) isStored(ct *scraper.CachedTweet) bool {
+	if a.store.Has(ct.Tweet.IdStr) == false {
+		return false
+	}
+	if ct.Source != scraper.SourceBookmark {
+		return true
+	}
+	stored, ok := a.tweets.Get(ct.Tweet.IdStr)
+	return ok == false || stored.Source == scraper.SourceBookmark
+}
+
+//
+// storeTweet
+// @Description: Assign the next bookmark index to bookmarks, save the tweet, fetch all of its media files and make it
+// available
+// @receiver a *Application
+// @param ctx context.Context
+// @param ct *scraper.CachedTweet
+// @param download func(ctx context.Context, src, target string) error fetches a single media file. Failures are ignored.
+// @return error
+func (a *Application) storeTweet(ctx context.Context, ct *scraper.CachedTweet, download func(ctx context.Context, src, target string) error) error {
+	// Tweets imported from an archive aren't bookmarks and receive an index once they get bookmarked
+	ct.Index = 0
+	if ct.Source == scraper.SourceBookmark {
+		ct.Index = a.tweets.NextIndex()
+	}
+	if err := a.store.Save(ct); err != nil {
+		return err
+	}
 
-				r := NewResponse()
-				r.Data["user"] = ct.User
-				r.Data["tweet"] = ct.Tweet
-				r.Data["conversation"] = ct.Conversation
+	for _, file := range a.MediaFiles(ct) {
+		_ = download(ctx, file.Source, file.Target)
+	}
 
-				if b, e := r.Encode(); e == nil {
-					a.Server.Hub().Broadcast(b)
-				} else {
-					log.Error("Failed to encode response: %s", err.Error())
-					return false
-				}
+	// Make the tweet available once all media files have been downloaded
+	a.tweets.Add(ct)
+	return nil
+}
+
+//
+// MediaFiles
+// @Description: List the profile image as well as all photos and videos of a tweet and its conversation
+// @receiver a *Application
+// @param ct *scraper.CachedTweet
+// @return []MediaFile
+func (a *Application) MediaFiles(ct *scraper.CachedTweet) []MediaFile {
+	files := make([]MediaFile, 0)
+	if ct.User.Legacy.ProfileImageUrlHttps != "" {
+		files = append(files, a.mediaFile(ct.User.Legacy.ProfileImageUrlHttps, ct.User.RestId))
+	}
+	for _, tweet := range ct.Conversation.GlobalObjects.Tweets {
+		for _, media := range a.tweetMedia(&tweet) {
+			files = append(files, media.Photo)
+			if media.Video != nil {
+				files = append(files, *media.Video)
 			}
 		}
+	}
+	return files
+}
 
-		if err != nil {
-			log.Error("Failed to save tweet data: %s", err.Error())
-			return false
-		} else {
-			log.Success("New tweet fetched: %s posted on %s", ct.Tweet.IdStr, ct.Tweet.CreatedAt)
-
-			if a.Danger.RemoveBookmarks {
-				r, err := a.Scraper.DeleteBookmarkDetail(ct.Tweet.IdStr)
-				if err != nil {
-					log.Error("Failed to remove remote bookmark %s: %s", ct.Tweet.IdStr, err.Error())
-				} else if r.Data.TweetBookmarkDelete != "Done" {
-					log.Info("Bookmark %s was already removed", ct.Tweet.IdStr)
-				} else {
-					log.Success("Bookmark removed: %s posted on %s", ct.Tweet.IdStr, ct.Tweet.CreatedAt)
+// tweetMedia is a photo or video attached to a tweet
+type tweetMedia struct {
+	Id      string
+	Type    string
+	AltText string
+	// Photo is the image itself or the preview image of a video
+	Photo MediaFile
+	// Video is the variant with the highest bitrate, nil for photos
+	Video *MediaFile
+}
+
+// tweetMedia lists the photos and videos attached to a single tweet
+func (a *Application) tweetMedia(tweet *scraper.TweetResult) []tweetMedia {
+	media := make([]tweetMedia, 0, len(tweet.ExtendedEntities.Media))
+	for _, ctm := range tweet.ExtendedEntities.Media {
+		m := tweetMedia{
+			Id:      ctm.IdStr,
+			Type:    ctm.Type,
+			AltText: ctm.ExtAltText,
+			Photo:   a.mediaFile(ctm.MediaUrlHttps, ctm.IdStr),
+		}
+
+		if ctm.Type == "video" {
+			maxBitrate := 0
+			videoUrl := ""
+			for _, variant := range ctm.VideoInfo.Variants {
+				if variant.Bitrate > maxBitrate {
+					videoUrl = strings.TrimSuffix(variant.Url, "?tag=10")
+					maxBitrate = variant.Bitrate
 				}
 			}
+
+			if videoUrl != "" {
+				video := a.mediaFile(videoUrl, ctm.IdStr)
+				m.Video = &video
+			}
 		}
-	} else {
-		log.Info("Tweet skipped (already fetched): %s posted on %s", ct.Tweet.IdStr, ct.Tweet.CreatedAt)
+		media = append(media, m)
 	}
+	return media
+}
+
+// mediaFile stores a remote file inside the media directory using the given name and the extension of the url
+func (a *Application) mediaFile(src, name string) MediaFile {
+	ext, _ := GetFileExtensionFromUrl(src)
+	if ext == "" {
+		ext = "blob"
+	}
+	return MediaFile{
+		Source: src,
+		Target: path.Join(a.DataDir, "media", name+"."+ext),
+	}
+}
 
-	return true
+// onTweetAdded indexes a new tweet and notifies all subscribed clients
+func (a *Application) onTweetAdded(ct *scraper.CachedTweet) {
+	a.indexTweet(ct)
+	if err := a.publish(TweetAddedEvent, map[string]interface{}{
+		"index":        ct.Index,
+		"user":         ct.User,
+		"tweet":        ct.Tweet,
+		"conversation": ct.Conversation,
+	}); err != nil {
+		log.Error("Failed to publish tweet %s: %s", ct.Tweet.IdStr, err.Error())
+	}
 }
 
 func (a *Application) GetTweets() []*scraper.CachedTweet {
-	return a.tweets
+	return a.tweets.Snapshot()
 }
 
 func GetFileExtensionFromUrl(rawUrl string) (string, error) {
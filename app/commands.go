@@ -0,0 +1,284 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"tbm/scraper"
+	"tbm/server"
+	"tbm/utils/log"
+)
+
+// ThreadItem is a single tweet of a conversation and its author
+type ThreadItem struct {
+	Tweet scraper.TweetResult      `json:"tweet"`
+	User  scraper.ConversationUser `json:"user"`
+}
+
+//
+// handleTask
+// @Description: Execute a given task. This is shared by the websocket and the http api.
+// @receiver a *Application
+// @param t *Task
+// @param r *Response
+func (a *Application) handleTask(t *Task, r *Response) {
+	switch t.Command {
+	case "get_tweets":
+		a.getTweets(t, r)
+	case "get_tweet":
+		a.getTweet(t, r)
+	case "get_thread":
+		a.getThread(t, r)
+	case "search_tweets":
+		a.searchTweets(t, r)
+	case "export_tweets":
+		a.exportTweets(t, r)
+	case "get_user":
+		a.getUser(t, r)
+	case "get_stats":
+		a.getStats(t, r)
+	case "get_rate_limit":
+		a.getRateLimit(t, r)
+	default:
+		r.SetErrorStr("unknown command")
+	}
+}
+
+//
+// Execute
+// @Description: Execute a command outside of the websocket and http api, e.g. from the command line
+// @receiver a *Application
+// @param command string
+// @param payload map[string]interface{}
+// @return *Response
+func (a *Application) Execute(command string, payload map[string]interface{}) *Response {
+	r := NewResponse()
+	a.handleTask(&Task{
+		Command: command,
+		Payload: payload,
+	}, r)
+	return r
+}
+
+//
+// apiCallback
+// @Description: Execute a command received through the http api
+// @receiver a *Application
+// @param command string
+// @param payload map[string]interface{}
+// @return int http status code
+// @return []byte encoded response
+func (a *Application) apiCallback(command string, payload map[string]interface{}) (int, []byte) {
+	r := a.Execute(command, payload)
+
+	b, err := r.Encode()
+	if err != nil {
+		log.Error("failed to encode response: %s", err.Error())
+		b, _ = json.Marshal(NewResponse())
+		return http.StatusInternalServerError, b
+	}
+	return r.Status, b
+}
+
+//
+// exportCallback
+// @Description: Prepare an export requested through the http api
+// @receiver a *Application
+// @param payload map[string]interface{}
+// @return server.Export nil if the payload is invalid
+// @return int http status code of the error response
+// @return []byte encoded error response
+func (a *Application) exportCallback(payload map[string]interface{}) (server.Export, int, []byte) {
+	e, err := a.NewTableExport(payload)
+	if err == nil {
+		return e, http.StatusOK, nil
+	}
+
+	r := NewResponse()
+	r.SetError(err)
+	b, _ := r.Encode()
+	return nil, r.Status, b
+}
+
+//
+// subscribe
+// @Description: Subscribe or unsubscribe a websocket client to or from the events given in the payload
+// @receiver a *Application
+// @param t *Task
+// @param r *Response
+// @param client *server.WebsocketClient
+func (a *Application) subscribe(t *Task, r *Response, client *server.WebsocketClient) {
+	events, _ := t.Payload["events"].([]interface{})
+	if len(events) == 0 {
+		r.SetErrorStr("events parameter not found")
+		return
+	}
+
+	for _, _event := range events {
+		event, _ := _event.(string)
+		if containsString(Events, event) == false {
+			r.SetErrorStr("unknown event \"" + event + "\" (expected one of " + strings.Join(Events, ", ") + ")")
+			continue
+		}
+		if t.Command == "subscribe" {
+			client.Subscribe(event)
+		} else {
+			client.Unsubscribe(event)
+		}
+	}
+
+	subscriptions := client.Subscriptions()
+	sort.Strings(subscriptions)
+	r.Data["subscriptions"] = subscriptions
+}
+
+//
+// publish
+// @Description: Push an event to all subscribed websocket clients
+// @receiver a *Application
+// @param event string
+// @param data map[string]interface{}
+// @return error
+func (a *Application) publish(event string, data map[string]interface{}) error {
+	r := NewEvent(event)
+	r.Data = data
+
+	b, err := r.Encode()
+	if err != nil {
+		log.Error("Failed to encode %s event: %s", event, err.Error())
+		return err
+	}
+	a.Server.Hub().Publish(event, b)
+
+	return nil
+}
+
+func (a *Application) onScraperProgress(p *scraper.Progress) {
+	_ = a.publish(ScraperProgressEvent, map[string]interface{}{
+		"progress": p,
+	})
+}
+
+func (a *Application) onRateLimit(state scraper.RateLimit) {
+	_ = a.publish(RateLimitEvent, map[string]interface{}{
+		"rate_limit": state,
+	})
+}
+
+func (a *Application) getRateLimit(t *Task, r *Response) {
+	r.Data["rate_limit"] = a.Scraper.RateLimit()
+}
+
+func (a *Application) onScraperError(err error) {
+	_ = a.publish(ScraperErrorEvent, map[string]interface{}{
+		"error": err.Error(),
+	})
+}
+
+func (a *Application) getTweets(t *Task, r *Response) {
+	opts, err := ParseListOptions(t.Payload, SortCreatedAt, OrderAsc)
+	if err != nil {
+		r.SetError(err)
+		return
+	}
+	opts.Apply(a.tweets.Snapshot(), r)
+}
+
+func (a *Application) findTweet(t *Task, r *Response) *scraper.CachedTweet {
+	id, _ := t.Payload["id"].(string)
+	if id == "" {
+		r.SetErrorStr("id parameter not found")
+		return nil
+	}
+	if ct, ok := a.tweets.Get(id); ok {
+		return ct
+	}
+	r.SetErrorStatus(http.StatusNotFound, "tweet not found")
+	return nil
+}
+
+func (a *Application) getTweet(t *Task, r *Response) {
+	if ct := a.findTweet(t, r); ct != nil {
+		r.Data["index"] = ct.Index
+		r.Data["user"] = ct.User
+		r.Data["tweet"] = ct.Tweet
+		r.Data["conversation"] = ct.Conversation
+		r.Data["source"] = sourceName(ct)
+	}
+}
+
+func (a *Application) getThread(t *Task, r *Response) {
+	ct := a.findTweet(t, r)
+	if ct == nil {
+		return
+	}
+
+	thread := make([]ThreadItem, 0)
+	for _, tweet := range ct.Conversation.Thread() {
+		thread = append(thread, ThreadItem{
+			Tweet: tweet,
+			User:  *ct.Conversation.GetUser(tweet.UserIdStr),
+		})
+	}
+	r.Data["id"] = ct.Tweet.IdStr
+	r.Data["thread"] = thread
+}
+
+func (a *Application) getUser(t *Task, r *Response) {
+	id, _ := t.Payload["id"].(string)
+	if id == "" {
+		r.SetErrorStr("id parameter not found")
+		return
+	}
+	screenName := strings.ToLower(strings.TrimPrefix(id, "@"))
+
+	var user *scraper.UserResult
+	count := 0
+	for _, ct := range a.tweets.Snapshot() {
+		if ct.User.RestId == id || strings.ToLower(ct.User.Legacy.ScreenName) == screenName {
+			user = &ct.User
+			count++
+		}
+	}
+	if user == nil {
+		r.SetErrorStatus(http.StatusNotFound, "user not found")
+		return
+	}
+
+	r.Data["user"] = user
+	r.Data["tweets"] = count
+}
+
+func (a *Application) getStats(t *Task, r *Response) {
+	users := map[string]int{}
+	languages := map[string]int{}
+	media := 0
+	conversationTweets := 0
+	oldest, newest := "", ""
+	tweets := a.tweets.Snapshot()
+	for _, ct := range tweets {
+		users[ct.User.RestId]++
+		languages[ct.Tweet.Lang]++
+		conversationTweets += len(ct.Conversation.GlobalObjects.Tweets)
+		for _, tweet := range ct.Conversation.GlobalObjects.Tweets {
+			media += len(tweet.ExtendedEntities.Media)
+		}
+
+		createdAt := scraper.ParseTime(ct.Tweet.CreatedAt)
+		if oldest == "" || createdAt.Before(scraper.ParseTime(oldest)) {
+			oldest = ct.Tweet.CreatedAt
+		}
+		if newest == "" || createdAt.After(scraper.ParseTime(newest)) {
+			newest = ct.Tweet.CreatedAt
+		}
+	}
+
+	r.Data["tweets"] = len(tweets)
+	r.Data["users"] = len(users)
+	r.Data["conversation_tweets"] = conversationTweets
+	r.Data["media"] = media
+	r.Data["languages"] = languages
+	r.Data["oldest"] = oldest
+	r.Data["newest"] = newest
+}
@@ -0,0 +1,159 @@
+package app
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"tbm/scraper"
+	"tbm/storage"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// JsonExport writes one json file per tweet, the same format as the json storage driver
+	JsonExport = "json"
+	// MarkdownExport writes one Markdown note per tweet as well as notes per author and hashtag (e.g. an Obsidian vault)
+	MarkdownExport = "markdown"
+)
+
+// ExportFormats lists all formats supported by Export
+var ExportFormats = []string{JsonExport, MarkdownExport}
+
+//
+// Export
+// @Description: Export all stored tweets in the given format
+// @receiver a *Application
+// @param format string
+// @param target string directory to export into
+// @return int number of exported tweets
+// @return error
+func (a *Application) Export(format, target string) (int, error) {
+	tweets := a.tweets.Snapshot()
+
+	switch format {
+	case JsonExport:
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return 0, err
+		}
+		dst := storage.NewJsonStorage(target)
+		for i, ct := range tweets {
+			if err := dst.Save(ct); err != nil {
+				return i, fmt.Errorf("failed to export tweet %s: %w", ct.Tweet.IdStr, err)
+			}
+		}
+		return len(tweets), nil
+	case MarkdownExport:
+		return len(tweets), a.exportMarkdown(tweets, target)
+	}
+	return 0, fmt.Errorf("unknown export format \"%s\" (expected %s)", format, strings.Join(ExportFormats, ", "))
+}
+
+//
+// TweetUrl
+// @Description: Build the permalink of a tweet
+// @param screenName string may be empty if the author is unknown
+// @param id string
+// @return string
+func TweetUrl(screenName, id string) string {
+	if screenName == "" {
+		return "https://twitter.com/i/web/status/" + id
+	}
+	return "https://twitter.com/" + screenName + "/status/" + id
+}
+
+// tweetText returns the text of a tweet with expanded urls and without the links to its media
+func tweetText(tweet *scraper.TweetResult) string {
+	text := tweet.FullText
+	for _, u := range tweet.Entities.Urls {
+		if u.Url != "" && u.ExpandedUrl != "" {
+			text = strings.ReplaceAll(text, u.Url, u.ExpandedUrl)
+		}
+	}
+	for _, m := range tweet.Entities.Media {
+		if m.Url != "" {
+			text = strings.ReplaceAll(text, m.Url, "")
+		}
+	}
+	for _, m := range tweet.ExtendedEntities.Media {
+		if m.Url != "" {
+			text = strings.ReplaceAll(text, m.Url, "")
+		}
+	}
+	return strings.TrimSpace(html.UnescapeString(text))
+}
+
+// tweetTitle consists of the author, the date and the beginning of the text limited to the given amount of characters
+func tweetTitle(ct *scraper.CachedTweet, loc *time.Location, length int) string {
+	text := strings.Join(strings.Fields(tweetText(&ct.Tweet)), " ")
+	if utf8.RuneCountInString(text) > length {
+		text = string([]rune(text)[:length]) + "…"
+	}
+	return fmt.Sprintf("@%s · %s · %s", ct.User.Legacy.ScreenName, scraper.ParseTime(ct.Tweet.CreatedAt).In(loc).Format("2006-01-02"), text)
+}
+
+func tweetHashtags(tweet *scraper.TweetResult) []string {
+	tags := make([]string, 0, len(tweet.Entities.Hashtags))
+	for _, tag := range tweet.Entities.Hashtags {
+		if tag.Text != "" && containsString(tags, tag.Text) == false {
+			tags = append(tags, tag.Text)
+		}
+	}
+	return tags
+}
+
+func tweetUrls(tweet *scraper.TweetResult) []string {
+	urls := make([]string, 0, len(tweet.Entities.Urls))
+	for _, u := range tweet.Entities.Urls {
+		if u.ExpandedUrl != "" && containsString(urls, u.ExpandedUrl) == false {
+			urls = append(urls, u.ExpandedUrl)
+		}
+	}
+	return urls
+}
+
+// location is the configured time zone used for dates inside exported files
+func (a *Application) location() *time.Location {
+	if loc, err := time.LoadLocation(a.Timezone); err == nil {
+		return loc
+	}
+	return time.UTC
+}
+
+// exportMedia copies a downloaded media file into the given directory and returns its name. The name is empty if the
+// file hasn't been downloaded.
+func exportMedia(file MediaFile, dir string) (string, error) {
+	name := path.Base(file.Target)
+	if stat, err := os.Stat(file.Target); err != nil || stat.Size() == 0 {
+		return "", nil
+	}
+	target := path.Join(dir, name)
+	if _, err := os.Stat(target); err == nil {
+		return name, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	src, err := os.Open(file.Target)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	dst, err := os.Create(target + ".tmp")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+		_ = os.Remove(target + ".tmp")
+		return "", err
+	}
+	if err := dst.Close(); err != nil {
+		return "", err
+	}
+	return name, os.Rename(target+".tmp", target)
+}
@@ -0,0 +1,240 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strings"
+	"tbm/scraper"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// GroupByAuthor creates one folder per author
+	GroupByAuthor = "author"
+	// GroupByHashtag creates one folder per hashtag, tweets without hashtags are placed inside the root folder
+	GroupByHashtag = "hashtag"
+	// GroupByNone places all links inside the root folder
+	GroupByNone = "none"
+)
+
+// BookmarkGroups lists all supported folder structures of a bookmark export
+var BookmarkGroups = []string{GroupByAuthor, GroupByHashtag, GroupByNone}
+
+// bookmarkTitleLength limits the tweet text used as title of a permalink
+const bookmarkTitleLength = 100
+
+// bookmarkFolder contains the tweets whose links are placed inside the same folder
+type bookmarkFolder struct {
+	name   string
+	tweets []*scraper.CachedTweet
+}
+
+// BookmarkExport writes the links of the tweets matching a search as Netscape bookmark file
+type BookmarkExport struct {
+	Group string
+	// Permalinks adds a link to every tweet itself
+	Permalinks bool
+	tweets     []*scraper.CachedTweet
+}
+
+//
+// NewBookmarkExport
+// @Description: Select the tweets of a bookmark export. The payload accepts the parameters of search_tweets as
+// well as group and permalinks.
+// @receiver a *Application
+// @param payload map[string]interface{}
+// @return *BookmarkExport
+// @return error
+func (a *Application) NewBookmarkExport(payload map[string]interface{}) (*BookmarkExport, error) {
+	e := &BookmarkExport{
+		Group:      GroupByAuthor,
+		Permalinks: true,
+	}
+	if v, ok := payload["group"].(string); ok && v != "" {
+		e.Group = v
+	}
+	if containsString(BookmarkGroups, e.Group) == false {
+		return nil, fmt.Errorf("unknown group \"%s\" (expected %s)", e.Group, strings.Join(BookmarkGroups, ", "))
+	}
+	if v, ok := payload["permalinks"].(bool); ok {
+		e.Permalinks = v
+	}
+
+	var err error
+	e.tweets, _, _, err = a.selectTweets(payload)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+//
+// Write
+// @Description: Write the bookmark file. All links are placed inside a single "Twitter bookmarks" folder.
+// Writing stops at the first error.
+// @receiver e *BookmarkExport
+// @param w io.Writer
+// @return int number of written links, 0 if the file is incomplete
+// @return error
+func (e *BookmarkExport) Write(w io.Writer) (int, error) {
+	bw := bufio.NewWriter(w)
+	_, err := bw.WriteString("<!DOCTYPE NETSCAPE-Bookmark-file-1>\n" +
+		"<!-- This is an automatically generated file.\n" +
+		"     It will be read and overwritten.\n" +
+		"     DO NOT EDIT! -->\n" +
+		"<META HTTP-EQUIV=\"Content-Type\" CONTENT=\"text/html; charset=UTF-8\">\n" +
+		"<TITLE>Bookmarks</TITLE>\n" +
+		"<H1>Bookmarks</H1>\n" +
+		"<DL><p>\n")
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	folders, rest := e.folders()
+	if err := e.writeFolder(bw, "Twitter bookmarks", e.tweets, 1); err != nil {
+		return 0, err
+	}
+	for _, folder := range folders {
+		if err := e.writeFolder(bw, folder.name, folder.tweets, 2); err != nil {
+			return 0, err
+		}
+		for _, ct := range folder.tweets {
+			n, err := e.writeLinks(bw, ct, 3)
+			if err != nil {
+				return 0, err
+			}
+			count += n
+		}
+		if _, err := bw.WriteString(strings.Repeat("    ", 2) + "</DL><p>\n"); err != nil {
+			return 0, err
+		}
+	}
+	for _, ct := range rest {
+		n, err := e.writeLinks(bw, ct, 2)
+		if err != nil {
+			return 0, err
+		}
+		count += n
+	}
+	if _, err := bw.WriteString("    </DL><p>\n</DL><p>\n"); err != nil {
+		return 0, err
+	}
+	// Buffered links are lost if the final flush fails
+	if err := bw.Flush(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// folders groups the tweets into folders sorted by name. Tweets without a folder are returned separately.
+func (e *BookmarkExport) folders() ([]*bookmarkFolder, []*scraper.CachedTweet) {
+	folders := map[string]*bookmarkFolder{}
+	rest := make([]*scraper.CachedTweet, 0)
+	add := func(key, name string, ct *scraper.CachedTweet) {
+		if _, ok := folders[key]; ok == false {
+			folders[key] = &bookmarkFolder{name: name}
+		}
+		folders[key].tweets = append(folders[key].tweets, ct)
+	}
+
+	for _, ct := range e.tweets {
+		if e.Permalinks == false && len(tweetUrls(&ct.Tweet)) == 0 {
+			continue
+		}
+		switch e.Group {
+		case GroupByAuthor:
+			user := ct.User.Legacy
+			if user.ScreenName == "" {
+				rest = append(rest, ct)
+				continue
+			}
+			add(strings.ToLower(user.ScreenName), fmt.Sprintf("%s (@%s)", user.Name, user.ScreenName), ct)
+		case GroupByHashtag:
+			hashtags := tweetHashtags(&ct.Tweet)
+			if len(hashtags) == 0 {
+				rest = append(rest, ct)
+				continue
+			}
+			for _, tag := range hashtags {
+				add(strings.ToLower(tag), "#"+tag, ct)
+			}
+		default:
+			rest = append(rest, ct)
+		}
+	}
+
+	sorted := make([]*bookmarkFolder, 0, len(folders))
+	for _, folder := range folders {
+		sorted = append(sorted, folder)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return strings.ToLower(sorted[i].name) < strings.ToLower(sorted[j].name)
+	})
+	return sorted, rest
+}
+
+// writeFolder opens a folder. Its ADD_DATE is the creation time of the newest tweet.
+func (e *BookmarkExport) writeFolder(w *bufio.Writer, name string, tweets []*scraper.CachedTweet, depth int) error {
+	var newest time.Time
+	for _, ct := range tweets {
+		if t := scraper.ParseTime(ct.Tweet.CreatedAt); t.After(newest) {
+			newest = t
+		}
+	}
+	indent := strings.Repeat("    ", depth)
+	_, err := fmt.Fprintf(w, "%s<DT><H3 ADD_DATE=\"%d\">%s</H3>\n%s<DL><p>\n", indent, bookmarkDate(newest), html.EscapeString(name), indent)
+	return err
+}
+
+// writeLinks writes the permalink and all expanded urls of a tweet
+func (e *BookmarkExport) writeLinks(w *bufio.Writer, ct *scraper.CachedTweet, depth int) (int, error) {
+	indent := strings.Repeat("    ", depth)
+	date := bookmarkDate(scraper.ParseTime(ct.Tweet.CreatedAt))
+	text := strings.Join(strings.Fields(tweetText(&ct.Tweet)), " ")
+	tags := strings.Join(tweetHashtags(&ct.Tweet), ",")
+
+	link := func(url, title string) error {
+		entry := fmt.Sprintf("%s<DT><A HREF=\"%s\" ADD_DATE=\"%d\"", indent, html.EscapeString(url), date)
+		if tags != "" {
+			entry += fmt.Sprintf(" TAGS=\"%s\"", html.EscapeString(tags))
+		}
+		entry += fmt.Sprintf(">%s</A>\n", html.EscapeString(title))
+		if text != "" {
+			entry += fmt.Sprintf("%s<DD>%s\n", indent, html.EscapeString(text))
+		}
+		_, err := w.WriteString(entry)
+		return err
+	}
+
+	count := 0
+	if e.Permalinks {
+		title := text
+		if utf8.RuneCountInString(title) > bookmarkTitleLength {
+			title = string([]rune(title)[:bookmarkTitleLength]) + "…"
+		}
+		if err := link(TweetUrl(ct.User.Legacy.ScreenName, ct.Tweet.IdStr), fmt.Sprintf("@%s: %s", ct.User.Legacy.ScreenName, title)); err != nil {
+			return count, err
+		}
+		count++
+	}
+	for _, url := range tweetUrls(&ct.Tweet) {
+		if err := link(url, url); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// bookmarkDate converts a time into the unix timestamp used by ADD_DATE, 0 if the time is unknown
+func bookmarkDate(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
@@ -0,0 +1,130 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"strings"
+	"tbm/scraper"
+	"testing"
+)
+
+// bookmarkEntry matches the folders and links of a bookmark file
+var bookmarkEntry = regexp.MustCompile(`^( *)<DT><(?:H3[^>]*>([^<]*)</H3>|A HREF="([^"]*)")`)
+
+// bookmarkTree describes the folders and links of a bookmark file, one line per entry indented by its depth
+func bookmarkTree(t *testing.T, file string) string {
+	t.Helper()
+
+	tree := make([]string, 0)
+	depth := 0
+	for _, line := range strings.Split(file, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "<DL><p>":
+			depth++
+		case trimmed == "</DL><p>":
+			depth--
+		default:
+			m := bookmarkEntry.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			if len(m[1]) != depth*4 {
+				t.Errorf("expected an indent of %d for %q", depth*4, line)
+			}
+			tree = append(tree, strings.Repeat("  ", depth-1)+m[2]+m[3])
+		}
+	}
+	if depth != 0 {
+		t.Errorf("expected all folders to be closed, got %d open", depth)
+	}
+	return strings.Join(tree, "\n")
+}
+
+func TestBookmarkExportFolders(t *testing.T) {
+	tweets := []*scraper.CachedTweet{
+		exportTweet(t, 3, "no hashtags"),
+		exportTweet(t, 2, "rust", "Rust"),
+		exportTweet(t, 1, "both", "golang", "rust"),
+	}
+	a := newExportApplication(t, tweets...)
+
+	tests := []struct {
+		group string
+		tree  string
+	}{
+		{GroupByAuthor, "Twitter bookmarks\n  Gopher (@gopher)\n" +
+			"    https://twitter.com/gopher/status/3\n    https://twitter.com/gopher/status/2\n    https://twitter.com/gopher/status/1"},
+		// Tweets are added to the folder of every hashtag, tweets without one to the root folder
+		{GroupByHashtag, "Twitter bookmarks\n  #golang\n    https://twitter.com/gopher/status/1\n" +
+			"  #Rust\n    https://twitter.com/gopher/status/2\n    https://twitter.com/gopher/status/1\n" +
+			"  https://twitter.com/gopher/status/3"},
+		{GroupByNone, "Twitter bookmarks\n" +
+			"  https://twitter.com/gopher/status/3\n  https://twitter.com/gopher/status/2\n  https://twitter.com/gopher/status/1"},
+	}
+	for _, test := range tests {
+		e, err := a.NewBookmarkExport(map[string]interface{}{"group": test.group, "sort": "index", "order": "desc"})
+		if err != nil {
+			t.Fatalf("%s: failed to create the export: %s", test.group, err.Error())
+		}
+		b := &bytes.Buffer{}
+		count, err := e.Write(b)
+		if err != nil {
+			t.Fatalf("%s: failed to write the export: %s", test.group, err.Error())
+		}
+		if strings.HasPrefix(b.String(), "<!DOCTYPE NETSCAPE-Bookmark-file-1>\n") == false {
+			t.Errorf("%s: expected a netscape bookmark file", test.group)
+		}
+		if tree := bookmarkTree(t, b.String()); tree != test.tree {
+			t.Errorf("%s: expected\n%s\ngot\n%s", test.group, test.tree, tree)
+		}
+		if expected := strings.Count(test.tree, "https://"); count != expected {
+			t.Errorf("%s: expected %d links, got %d", test.group, expected, count)
+		}
+	}
+
+	if _, err := a.NewBookmarkExport(map[string]interface{}{"group": "lang"}); err == nil {
+		t.Error("expected an unknown group to fail")
+	}
+}
+
+// failingWriter accepts a limited number of bytes
+type failingWriter struct {
+	limit int
+}
+
+var errWriteFailed = errors.New("write failed")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		n := w.limit
+		w.limit = 0
+		return n, errWriteFailed
+	}
+	w.limit -= len(p)
+	return len(p), nil
+}
+
+func TestBookmarkExportWriteError(t *testing.T) {
+	tweets := make([]*scraper.CachedTweet, 100)
+	for i := range tweets {
+		tweets[i] = exportTweet(t, i+1, strings.Repeat("long text ", 10))
+	}
+	a := newExportApplication(t, tweets...)
+	e, err := a.NewBookmarkExport(map[string]interface{}{"group": GroupByNone})
+	if err != nil {
+		t.Fatalf("failed to create the export: %s", err.Error())
+	}
+
+	// The file exceeds the write buffer, the smaller limits fail while adding links and the largest one during the final flush
+	for _, limit := range []int{0, 1000, 20000} {
+		count, err := e.Write(&failingWriter{limit: limit})
+		if errors.Is(err, errWriteFailed) == false || count != 0 {
+			t.Errorf("%d: expected no links and the write error, got %d (%v)", limit, count, err)
+		}
+	}
+	if count, err := e.Write(&failingWriter{limit: 1 << 20}); err != nil || count != len(tweets) {
+		t.Errorf("expected %d links, got %d (%v)", len(tweets), count, err)
+	}
+}
@@ -0,0 +1,382 @@
+package app
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"hash/crc32"
+	"html"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"tbm/scraper"
+	"time"
+)
+
+// epubTitleLength limits the tweet text used as chapter title
+const epubTitleLength = 60
+
+// epubImageTypes maps the extensions of all embeddable images onto their media type
+var epubImageTypes = map[string]string{
+	"jpg":  "image/jpeg",
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+	"webp": "image/webp",
+}
+
+const epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+const epubStyle = `body { font-family: serif; line-height: 1.4; }
+h1 { font-size: 1.4em; }
+.tweet { margin: 0 0 1.5em 0; }
+.reply { margin-left: 1.5em; padding-left: 0.5em; border-left: 2px solid #999; }
+.author { font-weight: bold; margin-bottom: 0.2em; }
+.date, .link { font-size: 0.8em; color: #666; }
+figure { margin: 0.5em 0; }
+img { max-width: 100%; }
+`
+
+// epubChapter is a single thread of an EPUB export
+type epubChapter struct {
+	id    string
+	title string
+	body  string
+}
+
+// epubImage is an embedded media file
+type epubImage struct {
+	id        string
+	name      string
+	mediaType string
+	source    string
+}
+
+// EpubExport creates an EPUB 3 book containing one chapter per thread
+type EpubExport struct {
+	Title string
+	// Replies adds the replies of other users, otherwise only the tweets of the bookmark author are added
+	Replies bool
+	tweets  []*scraper.CachedTweet
+	loc     *time.Location
+	// images maps media ids onto the embedded files
+	images map[string]*epubImage
+	order  []string
+}
+
+//
+// NewEpubExport
+// @Description: Select the threads of an EPUB export. The payload accepts either a list of tweet ids or the
+// parameters of search_tweets as well as title and replies.
+// @receiver a *Application
+// @param payload map[string]interface{}
+// @return *EpubExport
+// @return error
+func (a *Application) NewEpubExport(payload map[string]interface{}) (*EpubExport, error) {
+	e := &EpubExport{
+		Title:  "Twitter bookmarks",
+		loc:    a.location(),
+		images: map[string]*epubImage{},
+		order:  make([]string, 0),
+	}
+	if v, ok := payload["title"].(string); ok && v != "" {
+		e.Title = v
+	}
+	if v, ok := payload["replies"].(bool); ok {
+		e.Replies = v
+	}
+
+	ids := make([]string, 0)
+	switch _ids := payload["ids"].(type) {
+	case []interface{}:
+		for _, id := range _ids {
+			if s, ok := id.(string); ok && s != "" {
+				ids = append(ids, s)
+			}
+		}
+	case string:
+		for _, id := range strings.Split(_ids, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	if len(ids) > 0 {
+		// Every tweet becomes a chapter named after its id, so each one is added once only
+		seen := make(map[string]bool, len(ids))
+		for _, id := range ids {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			ct, ok := a.tweets.Get(id)
+			if ok == false {
+				return nil, fmt.Errorf("tweet %s not found", id)
+			}
+			e.tweets = append(e.tweets, siteTweet(ct))
+		}
+	} else {
+		tweets, _, _, err := a.selectTweets(payload)
+		if err != nil {
+			return nil, err
+		}
+		for _, ct := range tweets {
+			e.tweets = append(e.tweets, siteTweet(ct))
+		}
+	}
+	if len(e.tweets) == 0 {
+		return nil, fmt.Errorf("no tweets found")
+	}
+
+	// Videos are represented by their preview image
+	for _, ct := range e.tweets {
+		for _, tweet := range e.thread(ct) {
+			for _, media := range a.tweetMedia(&tweet) {
+				e.addImage(media.Id, media.Photo)
+			}
+		}
+	}
+	return e, nil
+}
+
+//
+// Write
+// @Description: Write the EPUB file
+// @receiver e *EpubExport
+// @param w io.Writer
+// @return int number of chapters
+// @return error
+func (e *EpubExport) Write(w io.Writer) (int, error) {
+	z := zip.NewWriter(w)
+
+	// The mimetype has to be the first, uncompressed file of the archive
+	mimetype := []byte("application/epub+zip")
+	f, err := z.CreateRaw(&zip.FileHeader{
+		Name:               "mimetype",
+		Method:             zip.Store,
+		CRC32:              crc32.ChecksumIEEE(mimetype),
+		CompressedSize64:   uint64(len(mimetype)),
+		UncompressedSize64: uint64(len(mimetype)),
+	})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.Write(mimetype); err != nil {
+		return 0, err
+	}
+
+	chapters := make([]*epubChapter, len(e.tweets))
+	for i, ct := range e.tweets {
+		chapters[i] = e.chapter(ct)
+	}
+
+	files := map[string]string{
+		"META-INF/container.xml": epubContainer,
+		"OEBPS/style.css":        epubStyle,
+		"OEBPS/content.opf":      e.packageDocument(chapters),
+		"OEBPS/nav.xhtml":        e.navDocument(chapters),
+		"OEBPS/toc.ncx":          e.ncxDocument(chapters),
+	}
+	for _, name := range []string{"META-INF/container.xml", "OEBPS/content.opf", "OEBPS/nav.xhtml", "OEBPS/toc.ncx", "OEBPS/style.css"} {
+		if err := writeZipFile(z, name, []byte(files[name])); err != nil {
+			return 0, err
+		}
+	}
+	for _, chapter := range chapters {
+		if err := writeZipFile(z, "OEBPS/chapters/"+chapter.id+".xhtml", []byte(chapter.body)); err != nil {
+			return 0, err
+		}
+	}
+	for _, id := range e.order {
+		image := e.images[id]
+		b, err := os.ReadFile(image.source)
+		if err != nil {
+			return 0, err
+		}
+		if err := writeZipFile(z, "OEBPS/images/"+image.name, b); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(chapters), z.Close()
+}
+
+// thread returns the tweets of a chapter in reply order
+func (e *EpubExport) thread(ct *scraper.CachedTweet) []scraper.TweetResult {
+	thread := make([]scraper.TweetResult, 0)
+	for _, tweet := range ct.Conversation.Thread() {
+		if e.Replies || tweet.IdStr == ct.Tweet.IdStr || tweet.UserIdStr == ct.User.RestId {
+			thread = append(thread, tweet)
+		}
+	}
+	return thread
+}
+
+// addImage embeds a downloaded image. Missing files and unsupported formats are skipped.
+func (e *EpubExport) addImage(id string, file MediaFile) {
+	if _, ok := e.images[id]; ok {
+		return
+	}
+	name := path.Base(file.Target)
+	mediaType, ok := epubImageTypes[strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))]
+	if ok == false {
+		return
+	}
+	if stat, err := os.Stat(file.Target); err != nil || stat.Size() == 0 {
+		return
+	}
+	e.images[id] = &epubImage{
+		id:        fmt.Sprintf("img%d", len(e.order)+1),
+		name:      name,
+		mediaType: mediaType,
+		source:    file.Target,
+	}
+	e.order = append(e.order, id)
+}
+
+// chapter renders the thread of a bookmark
+func (e *EpubExport) chapter(ct *scraper.CachedTweet) *epubChapter {
+	c := &epubChapter{
+		id:    "t" + ct.Tweet.IdStr,
+		title: tweetTitle(ct, e.loc, epubTitleLength),
+	}
+	body := &strings.Builder{}
+	fmt.Fprintf(body, "<h1>%s</h1>\n", html.EscapeString(c.title))
+	for _, tweet := range e.thread(ct) {
+		screenName, name := ct.User.Legacy.ScreenName, ct.User.Legacy.Name
+		class := "tweet"
+		if tweet.IdStr != ct.Tweet.IdStr {
+			user := ct.Conversation.GetUser(tweet.UserIdStr)
+			screenName, name = user.ScreenName, user.Name
+		}
+		if tweet.UserIdStr != ct.User.RestId && tweet.IdStr != ct.Tweet.IdStr {
+			class = "tweet reply"
+		}
+
+		fmt.Fprintf(body, "<div class=\"%s\">\n", class)
+		fmt.Fprintf(body, "<p class=\"author\">%s (@%s)</p>\n", html.EscapeString(name), html.EscapeString(screenName))
+		fmt.Fprintf(body, "<p class=\"date\">%s</p>\n", scraper.ParseTime(tweet.CreatedAt).In(e.loc).Format("2006-01-02 15:04"))
+		for _, paragraph := range strings.Split(tweetText(&tweet), "\n\n") {
+			if paragraph = strings.TrimSpace(paragraph); paragraph != "" {
+				fmt.Fprintf(body, "<p>%s</p>\n", strings.ReplaceAll(html.EscapeString(paragraph), "\n", "<br/>"))
+			}
+		}
+		for _, media := range tweet.ExtendedEntities.Media {
+			if image, ok := e.images[media.IdStr]; ok {
+				fmt.Fprintf(body, "<figure><img src=\"../images/%s\" alt=\"%s\"/></figure>\n", image.name, html.EscapeString(media.ExtAltText))
+			}
+		}
+		fmt.Fprintf(body, "<p class=\"link\">%s</p>\n", html.EscapeString(TweetUrl(screenName, tweet.IdStr)))
+		body.WriteString("</div>\n")
+	}
+
+	c.body = epubXhtml(c.title, "../style.css", body.String())
+	return c
+}
+
+// identifier derives a stable identifier of the book from the exported tweets
+func (e *EpubExport) identifier() string {
+	h := sha1.New()
+	for _, ct := range e.tweets {
+		h.Write([]byte(ct.Tweet.IdStr + "\n"))
+	}
+	b := h.Sum(nil)
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func (e *EpubExport) packageDocument(chapters []*epubChapter) string {
+	b := &strings.Builder{}
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+`)
+	fmt.Fprintf(b, "    <dc:identifier id=\"book-id\">%s</dc:identifier>\n", e.identifier())
+	fmt.Fprintf(b, "    <dc:title>%s</dc:title>\n", html.EscapeString(e.Title))
+	b.WriteString("    <dc:language>en</dc:language>\n")
+	b.WriteString("    <dc:creator>Twitter Bookmark Manager</dc:creator>\n")
+	fmt.Fprintf(b, "    <meta property=\"dcterms:modified\">%s</meta>\n", time.Now().UTC().Format("2006-01-02T15:04:05Z"))
+	b.WriteString(`  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+    <item id="style" href="style.css" media-type="text/css"/>
+`)
+	for _, chapter := range chapters {
+		fmt.Fprintf(b, "    <item id=\"%s\" href=\"chapters/%s.xhtml\" media-type=\"application/xhtml+xml\"/>\n", chapter.id, chapter.id)
+	}
+	for _, id := range e.order {
+		image := e.images[id]
+		fmt.Fprintf(b, "    <item id=\"%s\" href=\"images/%s\" media-type=\"%s\"/>\n", image.id, image.name, image.mediaType)
+	}
+	b.WriteString("  </manifest>\n  <spine toc=\"ncx\">\n    <itemref idref=\"nav\"/>\n")
+	for _, chapter := range chapters {
+		fmt.Fprintf(b, "    <itemref idref=\"%s\"/>\n", chapter.id)
+	}
+	b.WriteString("  </spine>\n</package>\n")
+	return b.String()
+}
+
+func (e *EpubExport) navDocument(chapters []*epubChapter) string {
+	body := &strings.Builder{}
+	fmt.Fprintf(body, "<nav epub:type=\"toc\" id=\"toc\">\n<h1>%s</h1>\n<ol>\n", html.EscapeString(e.Title))
+	for _, chapter := range chapters {
+		fmt.Fprintf(body, "<li><a href=\"chapters/%s.xhtml\">%s</a></li>\n", chapter.id, html.EscapeString(chapter.title))
+	}
+	body.WriteString("</ol>\n</nav>\n")
+	return epubXhtml(e.Title, "style.css", body.String())
+}
+
+// ncxDocument is the table of contents of EPUB 2, which is still required by some readers
+func (e *EpubExport) ncxDocument(chapters []*epubChapter) string {
+	b := &strings.Builder{}
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+  <head>
+`)
+	fmt.Fprintf(b, "    <meta name=\"dtb:uid\" content=\"%s\"/>\n", e.identifier())
+	fmt.Fprintf(b, "  </head>\n  <docTitle><text>%s</text></docTitle>\n  <navMap>\n", html.EscapeString(e.Title))
+	for i, chapter := range chapters {
+		fmt.Fprintf(b, "    <navPoint id=\"nav%d\" playOrder=\"%d\"><navLabel><text>%s</text></navLabel><content src=\"chapters/%s.xhtml\"/></navPoint>\n", i+1, i+1, html.EscapeString(chapter.title), chapter.id)
+	}
+	b.WriteString("  </navMap>\n</ncx>\n")
+	return b.String()
+}
+
+// epubXhtml wraps the body of a page into a XHTML document
+func epubXhtml(title, style, body string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head>
+<meta charset="UTF-8"/>
+<title>` + html.EscapeString(title) + `</title>
+<link rel="stylesheet" type="text/css" href="` + style + `"/>
+</head>
+<body>
+` + body + `</body>
+</html>
+`
+}
+
+// writeZipFile adds a compressed file to the archive
+func writeZipFile(z *zip.Writer, name string, b []byte) error {
+	w, err := z.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, bytes.NewReader(b))
+	return err
+}
@@ -0,0 +1,113 @@
+package app
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"path"
+	"testing"
+)
+
+// epubPackage contains the manifest and spine of the package document
+type epubPackage struct {
+	Manifest []struct {
+		Id   string `xml:"id,attr"`
+		Href string `xml:"href,attr"`
+	} `xml:"manifest>item"`
+	Spine []struct {
+		IdRef string `xml:"idref,attr"`
+	} `xml:"spine>itemref"`
+}
+
+// readEpub writes the export and returns its archive
+func readEpub(t *testing.T, e *EpubExport) (*zip.Reader, []byte, int) {
+	t.Helper()
+
+	b := &bytes.Buffer{}
+	count, err := e.Write(b)
+	if err != nil {
+		t.Fatalf("failed to write the export: %s", err.Error())
+	}
+	z, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatalf("failed to read the archive: %s", err.Error())
+	}
+	return z, b.Bytes(), count
+}
+
+func TestEpubMimetypeComesFirst(t *testing.T) {
+	a := newExportApplication(t, exportTweet(t, 1, "first"))
+	e, err := a.NewEpubExport(map[string]interface{}{"ids": "1"})
+	if err != nil {
+		t.Fatalf("failed to create the export: %s", err.Error())
+	}
+	z, b, _ := readEpub(t, e)
+
+	first := z.File[0]
+	if first.Name != "mimetype" || first.Method != zip.Store {
+		t.Fatalf("expected an uncompressed mimetype as first file, got %s (method %d)", first.Name, first.Method)
+	}
+	// Readers identify the format by the bytes following the first local file header
+	if string(b[30:58]) != "mimetypeapplication/epub+zip" {
+		t.Errorf("expected the mimetype at offset 30, got %q", b[30:58])
+	}
+}
+
+func TestEpubDuplicateIds(t *testing.T) {
+	a := newExportApplication(t, exportTweet(t, 1, "first"), exportTweet(t, 2, "second"))
+	e, err := a.NewEpubExport(map[string]interface{}{"ids": []interface{}{"1", "2", "1"}})
+	if err != nil {
+		t.Fatalf("failed to create the export: %s", err.Error())
+	}
+	z, _, count := readEpub(t, e)
+	if count != 2 {
+		t.Errorf("expected 2 chapters, got %d", count)
+	}
+
+	files := map[string]*zip.File{}
+	for _, f := range z.File {
+		if _, ok := files[f.Name]; ok {
+			t.Errorf("expected a single %s", f.Name)
+		}
+		files[f.Name] = f
+	}
+	f, ok := files["OEBPS/content.opf"]
+	if ok == false {
+		t.Fatal("expected a package document")
+	}
+	r, err := f.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg := &epubPackage{}
+	if err := xml.Unmarshal(data, pkg); err != nil {
+		t.Fatalf("invalid package document: %s", err.Error())
+	}
+
+	ids := map[string]bool{}
+	for _, item := range pkg.Manifest {
+		if ids[item.Id] {
+			t.Errorf("expected unique manifest ids, got %s twice", item.Id)
+		}
+		ids[item.Id] = true
+		if _, ok := files[path.Join("OEBPS", item.Href)]; ok == false {
+			t.Errorf("expected the manifest item %s inside the archive", item.Href)
+		}
+	}
+	spine := make([]string, len(pkg.Spine))
+	for i, item := range pkg.Spine {
+		spine[i] = item.IdRef
+		if ids[item.IdRef] == false {
+			t.Errorf("expected the spine item %s inside the manifest", item.IdRef)
+		}
+	}
+	if len(spine) != 3 || spine[1] != "t1" || spine[2] != "t2" {
+		t.Errorf("expected the navigation followed by one chapter per tweet, got %v", spine)
+	}
+}
@@ -0,0 +1,251 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"tbm/scraper"
+	"time"
+)
+
+// Directories of a Markdown export
+const (
+	markdownTweetDir   = "tweets"
+	markdownAuthorDir  = "authors"
+	markdownHashtagDir = "hashtags"
+	markdownMediaDir   = "media"
+)
+
+// markdownTitleLength limits the text shown in the links of author and hashtag notes
+const markdownTitleLength = 80
+
+var markdownUnsafe = regexp.MustCompile(`[^\p{L}\p{N}_-]+`)
+
+// markdownExport collects the notes linking to the exported tweets
+type markdownExport struct {
+	dir      string
+	loc      *time.Location
+	authors  map[string][]*scraper.CachedTweet
+	users    map[string]scraper.UserResult
+	hashtags map[string][]*scraper.CachedTweet
+	tags     map[string]string
+}
+
+//
+// exportMarkdown
+// @Description: Write a note with YAML front matter and the whole thread for every tweet, a note linking all
+// bookmarks of each author and hashtag and copy all downloaded media files into the target directory
+// @receiver a *Application
+// @param tweets []*scraper.CachedTweet
+// @param target string
+// @return error
+func (a *Application) exportMarkdown(tweets []*scraper.CachedTweet, target string) error {
+	for _, dir := range []string{markdownTweetDir, markdownAuthorDir, markdownHashtagDir, markdownMediaDir} {
+		if err := os.MkdirAll(path.Join(target, dir), 0755); err != nil {
+			return err
+		}
+	}
+
+	e := &markdownExport{
+		dir:      target,
+		loc:      a.location(),
+		authors:  map[string][]*scraper.CachedTweet{},
+		users:    map[string]scraper.UserResult{},
+		hashtags: map[string][]*scraper.CachedTweet{},
+		tags:     map[string]string{},
+	}
+	for _, ct := range tweets {
+		if name := markdownName(ct.User.Legacy.ScreenName); name != "" {
+			e.authors[name] = append(e.authors[name], ct)
+			e.users[name] = ct.User
+		}
+		for _, tag := range tweetHashtags(&ct.Tweet) {
+			name := markdownName(tag)
+			if name == "" {
+				// Hashtags consisting of unsafe characters only have no page
+				continue
+			}
+			e.hashtags[name] = append(e.hashtags[name], ct)
+			if _, ok := e.tags[name]; ok == false {
+				e.tags[name] = tag
+			}
+		}
+	}
+
+	for _, ct := range tweets {
+		note, err := a.markdownTweet(e, ct)
+		if err != nil {
+			return fmt.Errorf("failed to export tweet %s: %w", ct.Tweet.IdStr, err)
+		}
+		if err := writeFile(path.Join(target, markdownTweetDir, ct.Tweet.IdStr+".md"), []byte(note)); err != nil {
+			return err
+		}
+	}
+	for name, list := range e.authors {
+		user := e.users[name]
+		note := &strings.Builder{}
+		note.WriteString("---\n")
+		writeFrontMatter(note, "id", user.RestId)
+		writeFrontMatter(note, "screen_name", user.Legacy.ScreenName)
+		writeFrontMatter(note, "name", user.Legacy.Name)
+		writeFrontMatter(note, "url", "https://twitter.com/"+user.Legacy.ScreenName)
+		note.WriteString("---\n\n")
+		fmt.Fprintf(note, "# %s (@%s)\n\n", markdownEscape(user.Legacy.Name), user.Legacy.ScreenName)
+		if user.Legacy.Description != "" {
+			note.WriteString(markdownQuote(user.Legacy.Description) + "\n\n")
+		}
+		e.writeLinks(note, list)
+		if err := writeFile(path.Join(target, markdownAuthorDir, name+".md"), []byte(note.String())); err != nil {
+			return err
+		}
+	}
+	for name, list := range e.hashtags {
+		note := &strings.Builder{}
+		fmt.Fprintf(note, "# #%s\n\n", e.tags[name])
+		e.writeLinks(note, list)
+		if err := writeFile(path.Join(target, markdownHashtagDir, name+".md"), []byte(note.String())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markdownTweet renders the note of a single tweet. The thread is rendered in reply order.
+func (a *Application) markdownTweet(e *markdownExport, ct *scraper.CachedTweet) (string, error) {
+	hashtags := tweetHashtags(&ct.Tweet)
+	tags := make([]string, 0, len(hashtags))
+	for _, tag := range hashtags {
+		if name := markdownName(tag); name != "" {
+			tags = append(tags, name)
+		}
+	}
+
+	note := &strings.Builder{}
+	note.WriteString("---\n")
+	writeFrontMatter(note, "id", ct.Tweet.IdStr)
+	writeFrontMatter(note, "url", TweetUrl(ct.User.Legacy.ScreenName, ct.Tweet.IdStr))
+	writeFrontMatter(note, "author", ct.User.Legacy.ScreenName)
+	writeFrontMatter(note, "author_name", ct.User.Legacy.Name)
+	writeFrontMatter(note, "created_at", scraper.ParseTime(ct.Tweet.CreatedAt).In(e.loc))
+	writeFrontMatter(note, "index", ct.Index)
+	writeFrontMatter(note, "lang", ct.Tweet.Lang)
+	writeFrontMatter(note, "source", sourceName(ct))
+	writeFrontMatter(note, "hashtags", hashtags)
+	writeFrontMatter(note, "urls", tweetUrls(&ct.Tweet))
+	writeFrontMatter(note, "tags", tags)
+	note.WriteString("---\n\n")
+	fmt.Fprintf(note, "# %s\n\n", markdownEscape(tweetTitle(ct, e.loc, markdownTitleLength)))
+
+	conversation := ct.Conversation
+	if _, ok := conversation.GlobalObjects.Tweets[ct.Tweet.IdStr]; ok == false {
+		conversation = ct.StandaloneConversation()
+	}
+	for _, tweet := range conversation.Thread() {
+		screenName, name := ct.User.Legacy.ScreenName, ct.User.Legacy.Name
+		if tweet.IdStr != ct.Tweet.IdStr {
+			user := conversation.GetUser(tweet.UserIdStr)
+			screenName, name = user.ScreenName, user.Name
+		}
+
+		author := markdownEscape(fmt.Sprintf("%s (@%s)", name, screenName))
+		if _, ok := e.authors[markdownName(screenName)]; ok && screenName != "" {
+			author = fmt.Sprintf("[%s](../%s/%s.md)", author, markdownAuthorDir, markdownName(screenName))
+		} else if screenName != "" {
+			author = fmt.Sprintf("[%s](https://twitter.com/%s)", author, screenName)
+		}
+		fmt.Fprintf(note, "## %s · %s", author, scraper.ParseTime(tweet.CreatedAt).In(e.loc).Format("2006-01-02 15:04"))
+		if tweet.IdStr == ct.Tweet.IdStr {
+			fmt.Fprintf(note, " · %s", sourceName(ct))
+		}
+		note.WriteString("\n\n")
+
+		if text := tweetText(&tweet); text != "" {
+			note.WriteString(text + "\n\n")
+		}
+		for _, media := range a.tweetMedia(&tweet) {
+			file := media.Photo
+			if media.Video != nil {
+				file = *media.Video
+			}
+			name, err := exportMedia(file, path.Join(e.dir, markdownMediaDir))
+			if err != nil {
+				return "", err
+			}
+			if name != "" {
+				fmt.Fprintf(note, "![%s](../%s/%s)\n\n", markdownEscape(media.AltText), markdownMediaDir, name)
+			} else {
+				fmt.Fprintf(note, "![%s](%s)\n\n", markdownEscape(media.AltText), file.Source)
+			}
+		}
+
+		links := []string{fmt.Sprintf("[Open on twitter](%s)", TweetUrl(screenName, tweet.IdStr))}
+		if tweet.IdStr == ct.Tweet.IdStr {
+			for _, tag := range hashtags {
+				if name := markdownName(tag); name != "" {
+					links = append(links, fmt.Sprintf("[#%s](../%s/%s.md)", tag, markdownHashtagDir, name))
+				}
+			}
+		}
+		note.WriteString(strings.Join(links, " · ") + "\n\n")
+	}
+	return note.String(), nil
+}
+
+// writeLinks lists links to the given tweets, newest first
+func (e *markdownExport) writeLinks(note *strings.Builder, tweets []*scraper.CachedTweet) {
+	sorted := make([]*scraper.CachedTweet, len(tweets))
+	copy(sorted, tweets)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return scraper.CompareIds(sorted[i].Tweet.IdStr, sorted[j].Tweet.IdStr) > 0
+	})
+	for _, ct := range sorted {
+		fmt.Fprintf(note, "- [%s](../%s/%s.md)\n", markdownEscape(tweetTitle(ct, e.loc, markdownTitleLength)), markdownTweetDir, ct.Tweet.IdStr)
+	}
+}
+
+// markdownName turns a screen name or hashtag into a file name
+func markdownName(name string) string {
+	return strings.Trim(markdownUnsafe.ReplaceAllString(strings.ToLower(name), "_"), "_")
+}
+
+// markdownEscape escapes all characters which would end a link label
+func markdownEscape(text string) string {
+	return strings.NewReplacer("\\", "\\\\", "[", "\\[", "]", "\\]", "\n", " ").Replace(text)
+}
+
+func markdownQuote(text string) string {
+	return "> " + strings.ReplaceAll(text, "\n", "\n> ")
+}
+
+// writeFrontMatter writes a single YAML key. Strings are quoted using the JSON syntax, which is valid YAML.
+func writeFrontMatter(note *strings.Builder, key string, value interface{}) {
+	switch v := value.(type) {
+	case string:
+		fmt.Fprintf(note, "%s: %s\n", key, jsonQuote(v))
+	case []string:
+		quoted := make([]string, len(v))
+		for i, item := range v {
+			quoted[i] = jsonQuote(item)
+		}
+		fmt.Fprintf(note, "%s: [%s]\n", key, strings.Join(quoted, ", "))
+	case time.Time:
+		fmt.Fprintf(note, "%s: %s\n", key, v.Format(time.RFC3339))
+	default:
+		fmt.Fprintf(note, "%s: %v\n", key, v)
+	}
+}
+
+// jsonQuote quotes a string as JSON without escaping HTML characters
+func jsonQuote(text string) string {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	// Encoding a string can't fail, invalid UTF-8 is replaced
+	_ = enc.Encode(text)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
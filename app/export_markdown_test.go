@@ -0,0 +1,117 @@
+package app
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"tbm/scraper"
+	"testing"
+)
+
+// frontMatter decodes the front matter of a note. Every value is expected to be valid JSON, which is valid YAML.
+func frontMatter(t *testing.T, note string) map[string]interface{} {
+	t.Helper()
+
+	parts := strings.SplitN(note, "---\n", 3)
+	if len(parts) != 3 || parts[0] != "" {
+		t.Fatalf("expected the note to start with front matter, got %q", note)
+	}
+	values := map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSuffix(parts[1], "\n"), "\n") {
+		kv := strings.SplitN(line, ": ", 2)
+		if len(kv) != 2 {
+			t.Fatalf("invalid front matter line %q", line)
+		}
+		var value interface{}
+		if err := json.Unmarshal([]byte(kv[1]), &value); err != nil {
+			// Dates are written as plain YAML timestamps
+			value = kv[1]
+		}
+		values[kv[0]] = value
+	}
+	return values
+}
+
+func TestMarkdownFrontMatterQuotesStrings(t *testing.T) {
+	ct := exportTweet(t, 1, "Title: \"quoted\" <b>text</b>\nsecond line")
+	ct.User.Legacy.Name = `Go: "the" <language>`
+	a := newExportApplication(t, ct)
+
+	dir := t.TempDir()
+	if err := a.exportMarkdown([]*scraper.CachedTweet{ct}, dir); err != nil {
+		t.Fatalf("failed to export: %s", err.Error())
+	}
+	b, err := os.ReadFile(path.Join(dir, markdownTweetDir, "1.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := frontMatter(t, string(b))
+	if values["author_name"] != ct.User.Legacy.Name || values["id"] != "1" || values["author"] != "gopher" {
+		t.Errorf("expected the strings to survive quoting, got %v", values)
+	}
+	if strings.Contains(string(b), `\u003c`) {
+		t.Error("expected html characters not to be escaped")
+	}
+
+	b, err = os.ReadFile(path.Join(dir, markdownAuthorDir, "gopher.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values := frontMatter(t, string(b)); values["name"] != ct.User.Legacy.Name {
+		t.Errorf("expected the author name %q, got %v", ct.User.Legacy.Name, values["name"])
+	}
+}
+
+func TestMarkdownHashtagFileNames(t *testing.T) {
+	tweets := []*scraper.CachedTweet{
+		exportTweet(t, 1, "hashtags", "GoLang", "../x", "💥"),
+		exportTweet(t, 2, "golang again", "golang"),
+	}
+	a := newExportApplication(t, tweets...)
+
+	dir := path.Join(t.TempDir(), "vault")
+	if err := a.exportMarkdown(tweets, dir); err != nil {
+		t.Fatalf("failed to export: %s", err.Error())
+	}
+
+	entries, err := os.ReadDir(path.Join(dir, markdownHashtagDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	if reflect.DeepEqual(names, []string{"golang.md", "x.md"}) == false {
+		t.Errorf("expected a page per valid hashtag, got %v", names)
+	}
+	if _, err := os.Stat(path.Join(path.Dir(dir), "x.md")); err == nil {
+		t.Error("expected the hashtag not to escape the hashtag directory")
+	}
+
+	b, err := os.ReadFile(path.Join(dir, markdownTweetDir, "1.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	note := string(b)
+	if strings.Contains(note, "/.md") {
+		t.Errorf("expected no link to a hashtag without a name, got %q", note)
+	}
+	if strings.Contains(note, "[#GoLang](../hashtags/golang.md)") == false || strings.Contains(note, "[#../x](../hashtags/x.md)") == false {
+		t.Errorf("expected links to all valid hashtags, got %q", note)
+	}
+	if tags := frontMatter(t, note)["tags"]; reflect.DeepEqual(tags, []interface{}{"golang", "x"}) == false {
+		t.Errorf("expected the tags golang and x, got %v", tags)
+	}
+
+	b, err = os.ReadFile(path.Join(dir, markdownHashtagDir, "golang.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "../tweets/1.md") == false || strings.Contains(string(b), "../tweets/2.md") == false {
+		t.Errorf("expected the hashtag page to link both tweets, got %q", string(b))
+	}
+}
@@ -0,0 +1,245 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"tbm/scraper"
+	"tbm/server"
+)
+
+// Directories of a static site export
+const (
+	siteThreadDir = "thread"
+	siteMediaDir  = "media"
+)
+
+// siteAssets are the embedded folders copied into every static site export and their target directory
+var siteAssets = map[string]string{
+	"static/public/css": "css",
+	"static/site":       "",
+}
+
+// siteEntry is a single tweet inside the search index of a static site
+type siteEntry struct {
+	Id       string   `json:"id"`
+	Index    int      `json:"index"`
+	User     string   `json:"user"`
+	Name     string   `json:"name"`
+	Avatar   string   `json:"avatar"`
+	Text     string   `json:"text"`
+	Date     string   `json:"date"`
+	Hashtags []string `json:"hashtags"`
+	Media    []string `json:"media"`
+	Thread   int      `json:"thread"`
+	Url      string   `json:"url"`
+}
+
+// siteMedia maps media ids onto their url relative to the root of the site. Files which haven't been downloaded are
+// linked to their remote url.
+type siteMedia struct {
+	photos map[string]string
+	videos map[string]string
+}
+
+//
+// ExportSite
+// @Description: Render a static html page for every thread and an index page with a client-side search into the
+// target directory, so the tweets can be browsed without the server
+// @receiver a *Application
+// @param target string
+// @return int number of exported tweets
+// @return error
+func (a *Application) ExportSite(target string) (int, error) {
+	tweets := make([]*scraper.CachedTweet, 0, a.tweets.Len())
+	for _, ct := range a.tweets.Snapshot() {
+		tweets = append(tweets, siteTweet(ct))
+	}
+	sort.SliceStable(tweets, func(i, j int) bool {
+		ti, tj := scraper.ParseTime(tweets[i].Tweet.CreatedAt), scraper.ParseTime(tweets[j].Tweet.CreatedAt)
+		if ti.Equal(tj) {
+			return tweets[i].Index > tweets[j].Index
+		}
+		return ti.After(tj)
+	})
+
+	for _, dir := range []string{siteThreadDir, siteMediaDir} {
+		if err := os.MkdirAll(path.Join(target, dir), 0755); err != nil {
+			return 0, err
+		}
+	}
+	for dir, dst := range siteAssets {
+		if err := a.copyAssets(dir, path.Join(target, dst)); err != nil {
+			return 0, err
+		}
+	}
+
+	media, err := a.exportSiteMedia(tweets, path.Join(target, siteMediaDir))
+	if err != nil {
+		return 0, err
+	}
+
+	state := map[string]interface{}{
+		"mode": OfflineMode.ToString(),
+	}
+	getState := func() map[string]interface{} {
+		return state
+	}
+	threadTemplate, err := server.ParseTemplates(a.assets, getState, media.urls("../"))
+	if err != nil {
+		return 0, err
+	}
+	indexTemplate, err := server.ParseTemplates(a.assets, getState, media.urls(""))
+	if err != nil {
+		return 0, err
+	}
+
+	entries := make([]siteEntry, len(tweets))
+	loc := a.location()
+	for i, ct := range tweets {
+		b := &bytes.Buffer{}
+		if err := threadTemplate.ExecuteTemplate(b, "thread", server.ThreadData(ct, state)); err != nil {
+			return i, fmt.Errorf("failed to export tweet %s: %w", ct.Tweet.IdStr, err)
+		}
+		if err := writeFile(path.Join(target, siteThreadDir, ct.Tweet.IdStr+".html"), b.Bytes()); err != nil {
+			return i, err
+		}
+
+		photos := make([]string, 0)
+		for _, m := range a.tweetMedia(&ct.Tweet) {
+			photos = append(photos, media.photos[m.Id])
+		}
+		entries[i] = siteEntry{
+			Id:       ct.Tweet.IdStr,
+			Index:    ct.Index,
+			User:     ct.User.Legacy.ScreenName,
+			Name:     ct.User.Legacy.Name,
+			Avatar:   media.photos[ct.User.RestId],
+			Text:     tweetText(&ct.Tweet),
+			Date:     scraper.ParseTime(ct.Tweet.CreatedAt).In(loc).Format("2006.01.02 15:04:05"),
+			Hashtags: tweetHashtags(&ct.Tweet),
+			Media:    photos,
+			Thread:   len(ct.Conversation.GlobalObjects.Tweets),
+			Url:      siteThreadDir + "/" + ct.Tweet.IdStr + ".html",
+		}
+	}
+
+	// The index is loaded as a script instead of being fetched, which browsers forbid for local files
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return 0, err
+	}
+	if err := writeFile(path.Join(target, "search-index.js"), []byte("window.tbmSearchIndex = "+string(b)+";\n")); err != nil {
+		return 0, err
+	}
+
+	index := &bytes.Buffer{}
+	if err := indexTemplate.ExecuteTemplate(index, "site", map[string]interface{}{
+		"State": state,
+		"Title": "Twitter Bookmark Manager",
+	}); err != nil {
+		return 0, err
+	}
+	return len(tweets), writeFile(path.Join(target, "index.html"), index.Bytes())
+}
+
+// siteTweet makes sure the thread of a tweet contains at least the tweet itself
+func siteTweet(ct *scraper.CachedTweet) *scraper.CachedTweet {
+	if _, ok := ct.Conversation.GlobalObjects.Tweets[ct.Tweet.IdStr]; ok {
+		return ct
+	}
+	c := *ct
+	c.Conversation = ct.StandaloneConversation()
+	return &c
+}
+
+// exportSiteMedia copies the profile images of all authors and the media files of all threads
+func (a *Application) exportSiteMedia(tweets []*scraper.CachedTweet, dir string) (*siteMedia, error) {
+	m := &siteMedia{
+		photos: map[string]string{},
+		videos: map[string]string{},
+	}
+	add := func(urls map[string]string, id string, file MediaFile) error {
+		if _, ok := urls[id]; ok {
+			return nil
+		}
+		name, err := exportMedia(file, dir)
+		if err != nil {
+			return err
+		}
+		urls[id] = file.Source
+		if name != "" {
+			urls[id] = siteMediaDir + "/" + name
+		}
+		return nil
+	}
+
+	for _, ct := range tweets {
+		if ct.User.Legacy.ProfileImageUrlHttps != "" {
+			if err := add(m.photos, ct.User.RestId, a.mediaFile(ct.User.Legacy.ProfileImageUrlHttps, ct.User.RestId)); err != nil {
+				return nil, err
+			}
+		}
+		for _, tweet := range ct.Conversation.GlobalObjects.Tweets {
+			for _, media := range a.tweetMedia(&tweet) {
+				if err := add(m.photos, media.Id, media.Photo); err != nil {
+					return nil, err
+				}
+				if media.Video == nil {
+					continue
+				}
+				if err := add(m.videos, media.Id, *media.Video); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return m, nil
+}
+
+// urls builds the template urls of pages placed inside a sub directory given by root (e.g. "../")
+func (m *siteMedia) urls(root string) server.TemplateUrls {
+	relative := func(url string) string {
+		if url == "" || strings.Contains(url, "://") {
+			return url
+		}
+		return root + url
+	}
+	return server.TemplateUrls{
+		Asset: func(name string) string {
+			return root + name
+		},
+		Home: func() string {
+			return root + "index.html"
+		},
+		Media: func(id string) string {
+			return relative(m.photos[id])
+		},
+		Video: func(id string) string {
+			return relative(m.videos[id])
+		},
+	}
+}
+
+// copyAssets copies an embedded directory into the target directory
+func (a *Application) copyAssets(dir, target string) error {
+	return fs.WalkDir(a.assets, dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		dst := path.Join(target, strings.TrimPrefix(strings.TrimPrefix(name, dir), "/"))
+		if d.IsDir() {
+			return os.MkdirAll(dst, 0755)
+		}
+		b, err := fs.ReadFile(a.assets, name)
+		if err != nil {
+			return err
+		}
+		return writeFile(dst, b)
+	})
+}
@@ -0,0 +1,286 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"tbm/scraper"
+	"time"
+)
+
+const (
+	// CsvExport writes one row per tweet including a header row
+	CsvExport = "csv"
+	// JsonLinesExport writes one json object per line and tweet
+	JsonLinesExport = "jsonl"
+)
+
+// ExportPageSize is the default and maximum number of rows returned by the export_tweets command. Clients page
+// through larger exports using the offset or download them at once from /api/v1/export.
+const ExportPageSize = 1000
+
+// TableFormats lists all formats supported by NewTableExport
+var TableFormats = []string{CsvExport, JsonLinesExport}
+
+// TableColumns lists all columns of a tabular export in their default order
+var TableColumns = []string{
+	"id", "url", "screen_name", "name", "created_at", "text", "lang", "likes", "retweets", "replies", "quotes",
+	"hashtags", "urls", "media", "index",
+}
+
+// TableExport flattens the tweets matching a search into rows
+type TableExport struct {
+	Format  string
+	Columns []string
+	// Total is the number of matching tweets before offset and limit have been applied
+	Total  int
+	tweets []*scraper.CachedTweet
+	opts   *ListOptions
+	loc    *time.Location
+}
+
+//
+// NewTableExport
+// @Description: Select the tweets and columns of a tabular export. The payload accepts the parameters of
+// search_tweets as well as format and columns.
+// @receiver a *Application
+// @param payload map[string]interface{}
+// @return *TableExport
+// @return error
+func (a *Application) NewTableExport(payload map[string]interface{}) (*TableExport, error) {
+	e := &TableExport{
+		Format:  CsvExport,
+		Columns: TableColumns,
+		loc:     a.location(),
+	}
+	if v, ok := payload["format"].(string); ok && v != "" {
+		e.Format = v
+	}
+	if containsString(TableFormats, e.Format) == false {
+		return nil, fmt.Errorf("unknown export format \"%s\" (expected %s)", e.Format, strings.Join(TableFormats, ", "))
+	}
+
+	if v, ok := payload["columns"]; ok && v != nil {
+		columns := make([]string, 0)
+		switch _columns := v.(type) {
+		case []interface{}:
+			for _, column := range _columns {
+				if c, ok := column.(string); ok {
+					columns = append(columns, c)
+				}
+			}
+		case string:
+			if _columns != "" {
+				columns = strings.Split(_columns, ",")
+			}
+		}
+		for _, column := range columns {
+			if containsString(TableColumns, column) == false {
+				return nil, fmt.Errorf("unknown column \"%s\" (expected %s)", column, strings.Join(TableColumns, ", "))
+			}
+		}
+		if len(columns) > 0 {
+			e.Columns = columns
+		}
+	}
+
+	var err error
+	e.tweets, e.opts, e.Total, err = a.selectTweets(payload)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+//
+// ContentType
+// @Description: Get the mime type of the export
+// @receiver e *TableExport
+// @return string
+func (e *TableExport) ContentType() string {
+	if e.Format == JsonLinesExport {
+		return "application/x-ndjson; charset=utf-8"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+//
+// FileName
+// @Description: Get the default file name of the export
+// @receiver e *TableExport
+// @return string
+func (e *TableExport) FileName() string {
+	return "bookmarks." + e.Format
+}
+
+//
+// Rows
+// @Description: Get all rows, each containing the values of the selected columns
+// @receiver e *TableExport
+// @return [][]interface{}
+func (e *TableExport) Rows() [][]interface{} {
+	rows := make([][]interface{}, len(e.tweets))
+	for i, ct := range e.tweets {
+		rows[i] = e.row(ct)
+	}
+	return rows
+}
+
+//
+// Write
+// @Description: Stream all rows into the given writer. Each row is written as soon as it has been built.
+// @receiver e *TableExport
+// @param w io.Writer
+// @return int number of written rows
+// @return error
+func (e *TableExport) Write(w io.Writer) (int, error) {
+	if e.Format == JsonLinesExport {
+		return e.writeJsonLines(w)
+	}
+	return e.writeCsv(w)
+}
+
+func (e *TableExport) writeCsv(w io.Writer) (int, error) {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(e.Columns); err != nil {
+		return 0, err
+	}
+
+	record := make([]string, len(e.Columns))
+	for i, ct := range e.tweets {
+		for j, value := range e.row(ct) {
+			switch v := value.(type) {
+			case string:
+				record[j] = csvText(v)
+			case []string:
+				record[j] = csvText(strings.Join(v, " "))
+			default:
+				record[j] = fmt.Sprint(v)
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return i, err
+		}
+	}
+	cw.Flush()
+	return len(e.tweets), cw.Error()
+}
+
+// csvText prevents spreadsheets from running texts starting like a formula (e.g. a tweet "=HYPERLINK(...)")
+func csvText(text string) string {
+	if text != "" && strings.ContainsRune("=+-@\t\r", rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
+
+func (e *TableExport) writeJsonLines(w io.Writer) (int, error) {
+	bw := bufio.NewWriter(w)
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	for i, ct := range e.tweets {
+		// The object is written by hand to keep the order of the columns
+		buf.Reset()
+		buf.WriteByte('{')
+		for j, value := range e.row(ct) {
+			if j > 0 {
+				buf.WriteByte(',')
+			}
+			// The encoder terminates every value with a newline
+			if err := enc.Encode(e.Columns[j]); err != nil {
+				return i, err
+			}
+			buf.Truncate(buf.Len() - 1)
+			buf.WriteByte(':')
+			if err := enc.Encode(value); err != nil {
+				return i, err
+			}
+			buf.Truncate(buf.Len() - 1)
+		}
+		buf.WriteString("}\n")
+		if _, err := bw.Write(buf.Bytes()); err != nil {
+			return i, err
+		}
+	}
+	return len(e.tweets), bw.Flush()
+}
+
+// row returns the values of all selected columns of a tweet
+func (e *TableExport) row(ct *scraper.CachedTweet) []interface{} {
+	row := make([]interface{}, len(e.Columns))
+	for i, column := range e.Columns {
+		switch column {
+		case "id":
+			row[i] = ct.Tweet.IdStr
+		case "url":
+			row[i] = TweetUrl(ct.User.Legacy.ScreenName, ct.Tweet.IdStr)
+		case "screen_name":
+			row[i] = ct.User.Legacy.ScreenName
+		case "name":
+			row[i] = ct.User.Legacy.Name
+		case "created_at":
+			row[i] = scraper.ParseTime(ct.Tweet.CreatedAt).In(e.loc).Format(time.RFC3339)
+		case "text":
+			row[i] = tweetText(&ct.Tweet)
+		case "lang":
+			row[i] = ct.Tweet.Lang
+		case "likes":
+			row[i] = ct.Tweet.FavoriteCount
+		case "retweets":
+			row[i] = ct.Tweet.RetweetCount
+		case "replies":
+			row[i] = ct.Tweet.ReplyCount
+		case "quotes":
+			row[i] = ct.Tweet.QuoteCount
+		case "hashtags":
+			row[i] = tweetHashtags(&ct.Tweet)
+		case "urls":
+			row[i] = tweetUrls(&ct.Tweet)
+		case "media":
+			ids := make([]string, 0, len(ct.Tweet.ExtendedEntities.Media))
+			for _, m := range ct.Tweet.ExtendedEntities.Media {
+				ids = append(ids, m.IdStr)
+			}
+			row[i] = ids
+		case "index":
+			row[i] = ct.Index
+		}
+	}
+	return row
+}
+
+func (a *Application) exportTweets(t *Task, r *Response) {
+	limit, err := payloadInt(t.Payload, "limit")
+	if err != nil {
+		r.SetError(err)
+		return
+	}
+	if limit == 0 || limit > ExportPageSize {
+		limit = ExportPageSize
+	}
+
+	// The whole response is held in memory, so unlike the streamed http export every page is limited
+	payload := make(map[string]interface{}, len(t.Payload)+1)
+	for k, v := range t.Payload {
+		payload[k] = v
+	}
+	payload["limit"] = float64(limit)
+
+	e, err := a.NewTableExport(payload)
+	if err != nil {
+		r.SetError(err)
+		return
+	}
+
+	r.Data["format"] = e.Format
+	r.Data["columns"] = e.Columns
+	r.Data["rows"] = e.Rows()
+	r.Data["total"] = e.Total
+	r.Data["offset"] = e.opts.Offset
+	r.Data["limit"] = e.opts.Limit
+}
@@ -0,0 +1,96 @@
+package app
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"tbm/scraper"
+	"testing"
+)
+
+func TestTableExportCsvEscapesFormulas(t *testing.T) {
+	texts := []string{`=HYPERLINK("https://evil.example", "click")`, "+1 for generics", "-5 degrees", "@gopher hi", "plain = text"}
+	tweets := make([]*scraper.CachedTweet, len(texts))
+	for i, text := range texts {
+		tweets[i] = exportTweet(t, i+1, text)
+	}
+	a := newExportApplication(t, tweets...)
+
+	e, err := a.NewTableExport(map[string]interface{}{"columns": "id,text", "sort": "index", "order": "desc"})
+	if err != nil {
+		t.Fatalf("failed to create the export: %s", err.Error())
+	}
+	b := &bytes.Buffer{}
+	if _, err := e.Write(b); err != nil {
+		t.Fatalf("failed to write the export: %s", err.Error())
+	}
+	records, err := csv.NewReader(b).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read the csv file: %s", err.Error())
+	}
+	if len(records) != len(texts)+1 {
+		t.Fatalf("expected a header and %d rows, got %d", len(texts), len(records))
+	}
+	for i, text := range texts {
+		expected := "'" + text
+		if text == "plain = text" {
+			expected = text
+		}
+		if cell := records[i+1][1]; cell != expected {
+			t.Errorf("expected %q, got %q", expected, cell)
+		}
+	}
+
+	// JSON Lines aren't interpreted by spreadsheets and keep the original text
+	e.Format = JsonLinesExport
+	b.Reset()
+	if _, err := e.Write(b); err != nil {
+		t.Fatalf("failed to write the export: %s", err.Error())
+	}
+	row := map[string]string{}
+	if err := json.Unmarshal([]byte(strings.SplitN(b.String(), "\n", 2)[0]), &row); err != nil || row["text"] != texts[0] {
+		t.Errorf("expected the raw text inside json lines, got %v (%v)", row, err)
+	}
+}
+
+func TestCsvText(t *testing.T) {
+	for text, expected := range map[string]string{"": "", "\tcell": "'\tcell", "\rcell": "'\rcell", "a=b": "a=b"} {
+		if escaped := csvText(text); escaped != expected {
+			t.Errorf("%q: expected %q, got %q", text, expected, escaped)
+		}
+	}
+}
+
+func TestExportTweetsPages(t *testing.T) {
+	tweets := make([]*scraper.CachedTweet, ExportPageSize+5)
+	for i := range tweets {
+		tweets[i] = exportTweet(t, i+1, "tweet")
+	}
+	a := newExportApplication(t, tweets...)
+
+	tests := []struct {
+		payload map[string]interface{}
+		rows    int
+		limit   int
+	}{
+		{map[string]interface{}{}, ExportPageSize, ExportPageSize},
+		{map[string]interface{}{"offset": float64(ExportPageSize)}, 5, ExportPageSize},
+		{map[string]interface{}{"limit": float64(10)}, 10, 10},
+		{map[string]interface{}{"limit": float64(ExportPageSize * 2)}, ExportPageSize, ExportPageSize},
+	}
+	for _, test := range tests {
+		r := NewResponse()
+		a.exportTweets(&Task{Command: "export_tweets", Payload: test.payload}, r)
+		if len(r.Errors) > 0 {
+			t.Fatalf("%v: %v", test.payload, r.Errors)
+		}
+		rows, _ := r.Data["rows"].([][]interface{})
+		if len(rows) != test.rows || r.Data["limit"] != test.limit || r.Data["total"] != len(tweets) {
+			t.Errorf("%v: expected %d of %d rows using limit %d, got %d of %v using %v", test.payload, test.rows, len(tweets), test.limit, len(rows), r.Data["total"], r.Data["limit"])
+		}
+	}
+	if _, ok := tests[0].payload["limit"]; ok {
+		t.Error("expected the payload of the task to stay unchanged")
+	}
+}
@@ -0,0 +1,58 @@
+package app
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"tbm/scraper"
+	"tbm/search"
+	"testing"
+)
+
+// newExportApplication creates an application serving the given tweets without loading any config or storage
+func newExportApplication(t *testing.T, tweets ...*scraper.CachedTweet) *Application {
+	t.Helper()
+
+	a := NewApplication(embed.FS{})
+	a.DataDir = t.TempDir()
+	a.index = search.NewIndex(path.Join(a.DataDir, "search.idx"))
+	a.tweets.Load(tweets)
+	for _, ct := range tweets {
+		a.indexTweet(ct)
+	}
+	return a
+}
+
+// exportTweet creates a bookmark of gopher containing the given text and hashtags
+func exportTweet(t *testing.T, id int, text string, hashtags ...string) *scraper.CachedTweet {
+	t.Helper()
+
+	tags := make([]map[string]string, len(hashtags))
+	for i, tag := range hashtags {
+		tags[i] = map[string]string{"text": tag}
+	}
+	b, err := json.Marshal(map[string]interface{}{
+		"user": map[string]interface{}{
+			"rest_id": "10",
+			"legacy":  map[string]string{"screen_name": "gopher", "name": "Gopher"},
+		},
+		"tweet": map[string]interface{}{
+			"id_str":     fmt.Sprintf("%d", id),
+			"full_text":  text,
+			"lang":       "en",
+			"created_at": fmt.Sprintf("Sat Jan 01 12:%02d:00 +0000 2022", id%60),
+			"entities":   map[string]interface{}{"hashtags": tags},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ct := &scraper.CachedTweet{}
+	if err := json.Unmarshal(b, ct); err != nil {
+		t.Fatal(err)
+	}
+	ct.Conversation = ct.StandaloneConversation()
+	return ct
+}
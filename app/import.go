@@ -0,0 +1,111 @@
+package app
+
+import (
+	"context"
+	"os"
+	"tbm/scraper"
+	"tbm/utils/log"
+)
+
+// ImportResult summarizes an import
+type ImportResult struct {
+	New     int `json:"new"`
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+	// Embedded media files have been extracted from the imported file instead of being downloaded
+	Embedded int `json:"embedded"`
+}
+
+//
+// ImportHar
+// @Description: Import all bookmarks captured inside a HAR file. Media files which haven't been captured are
+// downloaded unless the application runs in offline mode.
+// @receiver a *Application
+// @param ctx context.Context
+// @param filename string
+// @return *ImportResult
+// @return error
+func (a *Application) ImportHar(ctx context.Context, filename string) (*ImportResult, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	capture, err := scraper.ReadHar(f)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Found %d bookmarks, %d conversations and %d unavailable bookmarks on %d pages", len(capture.Tweets), len(capture.Conversations), capture.Empty, capture.Pages)
+
+	for _, ct := range capture.Tweets {
+		ct.Conversation = capture.Conversation(ct)
+	}
+	return a.importTweets(ctx, capture.Tweets, capture.Media)
+}
+
+//
+// ImportArchive
+// @Description: Import all tweets and likes of a twitter data archive. Media files which aren't part of the archive
+// are downloaded unless the application runs in offline mode.
+// @receiver a *Application
+// @param ctx context.Context
+// @param filename string
+// @return *ImportResult
+// @return error
+func (a *Application) ImportArchive(ctx context.Context, filename string) (*ImportResult, error) {
+	archive, err := scraper.OpenArchive(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+	log.Info("Found %d tweets and %d likes of @%s", len(archive.Tweets), len(archive.Likes), archive.User.Legacy.ScreenName)
+
+	return a.importTweets(ctx, append(archive.Tweets, archive.Likes...), archive.Media)
+}
+
+// importTweets stores all given tweets which haven't been stored before. Embedded media files are preferred over
+// downloading them.
+func (a *Application) importTweets(ctx context.Context, tweets []*scraper.CachedTweet, embedded func(src string) ([]byte, bool)) (*ImportResult, error) {
+	result := &ImportResult{}
+	download := func(ctx context.Context, src, target string) error {
+		if b, ok := embedded(src); ok {
+			result.Embedded++
+			return writeFile(target, b)
+		}
+		if a.Mode == OfflineMode {
+			return nil
+		}
+		return a.Scraper.Download(ctx, src, target)
+	}
+
+	for _, ct := range tweets {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		if a.isStored(ct) {
+			result.Skipped++
+			continue
+		}
+
+		if err := a.storeTweet(ctx, ct, download); err != nil {
+			log.Error("Failed to save tweet %s: %s", ct.Tweet.IdStr, err.Error())
+			result.Failed++
+			continue
+		}
+		log.Success("Tweet imported: %s posted on %s", ct.Tweet.IdStr, ct.Tweet.CreatedAt)
+		result.New++
+	}
+
+	log.Statistic("Import finished: %d new, %d skipped, %d failed, %d embedded media files", result.New, result.Skipped, result.Failed, result.Embedded)
+	return result, nil
+}
+
+// writeFile writes into a temporary file first, so an interrupted import never leaves a broken file behind
+func writeFile(target string, b []byte) error {
+	tmp := target + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, target)
+}
@@ -0,0 +1,237 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"tbm/scraper"
+)
+
+const (
+	SortRelevance = "relevance"
+	SortIndex     = "index"
+	SortCreatedAt = "created_at"
+	SortLikes     = "likes"
+	SortRetweets  = "retweets"
+
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+// listFields contains all fields a tweet can be projected onto
+var listFields = []string{"index", "user", "tweet", "conversation", "thread_length", "source"}
+
+// defaultListFields are returned if no fields have been requested
+var defaultListFields = []string{"index", "user", "tweet", "conversation"}
+
+// ListOptions describe which part of a tweet list should be returned and in which order
+type ListOptions struct {
+	Offset int
+	Limit  int
+	Sort   string
+	Order  string
+	Fields []string
+}
+
+//
+// ParseListOptions
+// @Description: Read offset, limit, sort, order and fields from a task payload
+// @param payload map[string]interface{}
+// @param defaultSort string
+// @param defaultOrder string
+// @return *ListOptions
+// @return error
+func ParseListOptions(payload map[string]interface{}, defaultSort, defaultOrder string) (*ListOptions, error) {
+	o := &ListOptions{
+		Sort:   defaultSort,
+		Order:  defaultOrder,
+		Fields: defaultListFields,
+	}
+
+	var err error
+	if o.Offset, err = payloadInt(payload, "offset"); err != nil {
+		return nil, err
+	}
+	if o.Limit, err = payloadInt(payload, "limit"); err != nil {
+		return nil, err
+	}
+
+	if v, ok := payload["sort"].(string); ok && v != "" {
+		o.Sort = v
+	}
+	switch o.Sort {
+	case SortIndex, SortCreatedAt, SortLikes, SortRetweets:
+	case SortRelevance:
+		if defaultSort != SortRelevance {
+			return nil, fmt.Errorf("sort \"%s\" is only available for searches", o.Sort)
+		}
+	default:
+		expected := "index, created_at, likes or retweets"
+		if defaultSort == SortRelevance {
+			expected = "relevance, " + expected
+		}
+		return nil, fmt.Errorf("unknown sort \"%s\" (expected %s)", o.Sort, expected)
+	}
+
+	if v, ok := payload["order"].(string); ok && v != "" {
+		o.Order = v
+	}
+	if o.Order != OrderAsc && o.Order != OrderDesc {
+		return nil, fmt.Errorf("unknown order \"%s\" (expected asc or desc)", o.Order)
+	}
+
+	if v, ok := payload["fields"]; ok && v != nil {
+		fields := make([]string, 0)
+		switch _fields := v.(type) {
+		case []interface{}:
+			for _, field := range _fields {
+				if f, ok := field.(string); ok {
+					fields = append(fields, f)
+				}
+			}
+		case string:
+			fields = strings.Split(_fields, ",")
+		}
+		for _, field := range fields {
+			if containsString(listFields, field) == false {
+				return nil, fmt.Errorf("unknown field \"%s\" (expected %s)", field, strings.Join(listFields, ", "))
+			}
+		}
+		if len(fields) > 0 {
+			o.Fields = fields
+		}
+	}
+
+	return o, nil
+}
+
+//
+// Apply
+// @Description: Sort, paginate and project the given tweets into the response
+// @receiver o *ListOptions
+// @param tweets []*scraper.CachedTweet
+// @param r *Response
+// @return []*scraper.CachedTweet the selected page
+func (o *ListOptions) Apply(tweets []*scraper.CachedTweet, r *Response) []*scraper.CachedTweet {
+	sorted := o.sort(tweets)
+	page := o.page(sorted)
+
+	items := make([]map[string]interface{}, len(page))
+	for i, ct := range page {
+		items[i] = o.project(ct)
+	}
+
+	r.Data["tweets"] = items
+	r.Data["total"] = len(sorted)
+	r.Data["offset"] = o.Offset
+	r.Data["limit"] = o.Limit
+
+	return page
+}
+
+// sort returns a sorted copy of the given tweets
+func (o *ListOptions) sort(tweets []*scraper.CachedTweet) []*scraper.CachedTweet {
+	sorted := make([]*scraper.CachedTweet, len(tweets))
+	copy(sorted, tweets)
+
+	if o.Sort == SortRelevance {
+		// The given tweets are already ordered by descending relevance
+		if o.Order == OrderAsc {
+			for i, j := 0, len(sorted)-1; i < j; i, j = i+1, j-1 {
+				sorted[i], sorted[j] = sorted[j], sorted[i]
+			}
+		}
+	} else {
+		sort.SliceStable(sorted, func(i, j int) bool {
+			if o.Order == OrderDesc {
+				return o.less(sorted[j], sorted[i])
+			}
+			return o.less(sorted[i], sorted[j])
+		})
+	}
+	return sorted
+}
+
+// page selects the tweets given by offset and limit
+func (o *ListOptions) page(sorted []*scraper.CachedTweet) []*scraper.CachedTweet {
+	total := len(sorted)
+	start, end := o.Offset, total
+	if start > total {
+		start = total
+	}
+	if o.Limit > 0 && start+o.Limit < end {
+		end = start + o.Limit
+	}
+	return sorted[start:end]
+}
+
+func (o *ListOptions) less(a, b *scraper.CachedTweet) bool {
+	switch o.Sort {
+	case SortIndex:
+		// Archived tweets without a bookmark index follow all bookmarks
+		return a.Index != 0 && (b.Index == 0 || a.Index < b.Index)
+	case SortLikes:
+		return a.Tweet.FavoriteCount < b.Tweet.FavoriteCount
+	case SortRetweets:
+		return a.Tweet.RetweetCount < b.Tweet.RetweetCount
+	}
+	return scraper.ParseTime(a.Tweet.CreatedAt).Before(scraper.ParseTime(b.Tweet.CreatedAt))
+}
+
+func (o *ListOptions) project(ct *scraper.CachedTweet) map[string]interface{} {
+	item := make(map[string]interface{}, len(o.Fields))
+	for _, field := range o.Fields {
+		switch field {
+		case "index":
+			item[field] = ct.Index
+		case "user":
+			item[field] = ct.User
+		case "tweet":
+			item[field] = ct.Tweet
+		case "conversation":
+			item[field] = ct.Conversation
+		case "thread_length":
+			item[field] = len(ct.Conversation.GlobalObjects.Tweets)
+		case "source":
+			item[field] = sourceName(ct)
+		}
+	}
+	return item
+}
+
+// sourceName returns "bookmark" for bookmarks and the source of all other tweets
+func sourceName(ct *scraper.CachedTweet) string {
+	if ct.Source == scraper.SourceBookmark {
+		return "bookmark"
+	}
+	return ct.Source
+}
+
+func payloadInt(payload map[string]interface{}, key string) (int, error) {
+	v, ok := payload[key]
+	if ok == false || v == nil {
+		return 0, nil
+	}
+	switch n := v.(type) {
+	case float64:
+		if n >= 0 && n == float64(int(n)) {
+			return int(n), nil
+		}
+	case string:
+		// Query parameters of the http api are passed as strings
+		if i, err := strconv.Atoi(n); err == nil && i >= 0 {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%s has to be a positive integer", key)
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
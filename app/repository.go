@@ -0,0 +1,163 @@
+package app
+
+import (
+	"sort"
+	"sync"
+	"tbm/scraper"
+)
+
+// DefaultBookmarkIndex is the index of the first bookmark. Every newer bookmark receives a lower index.
+const DefaultBookmarkIndex = 1000000
+
+// TweetRepository holds all loaded tweets and can be shared between the scraper and the websocket handlers.
+// The tweet list is copy-on-write: every change replaces the list, so snapshots can be used without locking.
+type TweetRepository struct {
+	tweets        []*scraper.CachedTweet
+	ids           map[string]*scraper.CachedTweet
+	bookmarkIndex int
+	listeners     []func(ct *scraper.CachedTweet)
+
+	mx sync.RWMutex
+}
+
+//
+// NewTweetRepository
+// @Description: Create a new and empty TweetRepository instance
+// @return *TweetRepository
+func NewTweetRepository() *TweetRepository {
+	return &TweetRepository{
+		tweets:        make([]*scraper.CachedTweet, 0),
+		ids:           map[string]*scraper.CachedTweet{},
+		bookmarkIndex: DefaultBookmarkIndex,
+		listeners:     make([]func(ct *scraper.CachedTweet), 0),
+	}
+}
+
+//
+// Load
+// @Description: Replace all tweets, assign missing bookmark indexes to bookmarks and sort them by creation date
+// @receiver r *TweetRepository
+// @param tweets []*scraper.CachedTweet
+func (r *TweetRepository) Load(tweets []*scraper.CachedTweet) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	sorted := make([]*scraper.CachedTweet, len(tweets))
+	copy(sorted, tweets)
+
+	r.bookmarkIndex = DefaultBookmarkIndex
+	r.ids = make(map[string]*scraper.CachedTweet, len(sorted))
+	for _, ct := range sorted {
+		if ct.Index != 0 && r.bookmarkIndex > ct.Index {
+			r.bookmarkIndex = ct.Index
+		} else if ct.Index == 0 && ct.Source == scraper.SourceBookmark {
+			ct.Index = r.bookmarkIndex - 1
+			r.bookmarkIndex = ct.Index
+		}
+		r.ids[ct.Tweet.IdStr] = ct
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return scraper.ParseTime(sorted[i].Tweet.CreatedAt).Before(scraper.ParseTime(sorted[j].Tweet.CreatedAt))
+	})
+	r.tweets = sorted
+}
+
+//
+// Snapshot
+// @Description: Get the current list of tweets. The list never changes and must not be modified.
+// @receiver r *TweetRepository
+// @return []*scraper.CachedTweet
+func (r *TweetRepository) Snapshot() []*scraper.CachedTweet {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	return r.tweets
+}
+
+func (r *TweetRepository) Len() int {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	return len(r.tweets)
+}
+
+func (r *TweetRepository) Get(id string) (*scraper.CachedTweet, bool) {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	ct, ok := r.ids[id]
+	return ct, ok
+}
+
+func (r *TweetRepository) Has(id string) bool {
+	_, ok := r.Get(id)
+	return ok
+}
+
+//
+// HasBookmark
+// @Description: Check whether a tweet has been stored as bookmark. Tweets imported from an archive aren't bookmarks.
+// @receiver r *TweetRepository
+// @param id string
+// @return bool
+func (r *TweetRepository) HasBookmark(id string) bool {
+	ct, ok := r.Get(id)
+	return ok && ct.Source == scraper.SourceBookmark
+}
+
+//
+// NextIndex
+// @Description: Reserve the bookmark index of the next new bookmark
+// @receiver r *TweetRepository
+// @return int
+func (r *TweetRepository) NextIndex() int {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	r.bookmarkIndex--
+	return r.bookmarkIndex
+}
+
+//
+// Add
+// @Description: Append a new tweet or replace an existing one with the same id and notify all listeners
+// @receiver r *TweetRepository
+// @param ct *scraper.CachedTweet
+// @return bool false if an existing tweet has been replaced
+func (r *TweetRepository) Add(ct *scraper.CachedTweet) bool {
+	r.mx.Lock()
+	_, exists := r.ids[ct.Tweet.IdStr]
+	if exists {
+		tweets := make([]*scraper.CachedTweet, len(r.tweets))
+		for i, t := range r.tweets {
+			tweets[i] = t
+			if t.Tweet.IdStr == ct.Tweet.IdStr {
+				tweets[i] = ct
+			}
+		}
+		r.tweets = tweets
+	} else {
+		// The full slice expression forces a new backing array, so existing snapshots stay untouched
+		r.tweets = append(r.tweets[:len(r.tweets):len(r.tweets)], ct)
+	}
+	r.ids[ct.Tweet.IdStr] = ct
+	listeners := r.listeners
+	r.mx.Unlock()
+
+	for _, listener := range listeners {
+		listener(ct)
+	}
+	return exists == false
+}
+
+//
+// OnAdd
+// @Description: Register a listener which gets called after a new tweet has been added
+// @receiver r *TweetRepository
+// @param listener func(ct *scraper.CachedTweet)
+func (r *TweetRepository) OnAdd(listener func(ct *scraper.CachedTweet)) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	r.listeners = append(r.listeners[:len(r.listeners):len(r.listeners)], listener)
+}
@@ -0,0 +1,47 @@
+package app
+
+import (
+	"tbm/scraper"
+	"testing"
+)
+
+func TestTweetRepositorySnapshotsStayUnchanged(t *testing.T) {
+	r := NewTweetRepository()
+	added := 0
+	r.OnAdd(func(ct *scraper.CachedTweet) {
+		added++
+	})
+
+	first := &scraper.CachedTweet{}
+	first.Tweet.IdStr = "1"
+	if r.Add(first) == false {
+		t.Fatal("expected a new tweet")
+	}
+	snapshot := r.Snapshot()
+
+	second := &scraper.CachedTweet{}
+	second.Tweet.IdStr = "2"
+	r.Add(second)
+	if len(snapshot) != 1 || r.Len() != 2 {
+		t.Fatalf("expected the snapshot to keep 1 of 2 tweets, got %d", len(snapshot))
+	}
+	if added != 2 {
+		t.Errorf("expected 2 notifications, got %d", added)
+	}
+}
+
+func TestTweetRepositoryLoadSkipsArchivedTweets(t *testing.T) {
+	bookmark := &scraper.CachedTweet{}
+	bookmark.Tweet.IdStr = "1"
+	archived := &scraper.CachedTweet{Source: scraper.SourceArchive}
+	archived.Tweet.IdStr = "2"
+
+	r := NewTweetRepository()
+	r.Load([]*scraper.CachedTweet{bookmark, archived})
+	if bookmark.Index != DefaultBookmarkIndex-1 || archived.Index != 0 {
+		t.Errorf("expected only the bookmark to receive an index, got %d and %d", bookmark.Index, archived.Index)
+	}
+	if next := r.NextIndex(); next != DefaultBookmarkIndex-2 {
+		t.Errorf("expected the next index to follow the bookmark, got %d", next)
+	}
+}
@@ -1,27 +1,72 @@
 package app
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"net/http"
+)
+
+const (
+	// ProtocolVersion is increased with every incompatible change of the websocket protocol
+	ProtocolVersion = 2
+
+	ResponseType = "response"
+	EventType    = "event"
+)
+
+const (
+	TweetAddedEvent      = "tweet.added"
+	ScraperProgressEvent = "scraper.progress"
+	ScraperErrorEvent    = "scraper.error"
+	RateLimitEvent       = "scraper.rate_limit"
+)
+
+// Events lists all events a websocket client can subscribe to
+var Events = []string{TweetAddedEvent, ScraperProgressEvent, ScraperErrorEvent, RateLimitEvent}
 
 type Response struct {
-	Errors []string               `json:"errors"`
-	Data   map[string]interface{} `json:"data"`
+	ID      string                 `json:"id,omitempty"`
+	Version int                    `json:"version"`
+	Type    string                 `json:"type"`
+	Event   string                 `json:"event,omitempty"`
+	Errors  []string               `json:"errors"`
+	Data    map[string]interface{} `json:"data"`
+	Status  int                    `json:"-"`
 }
 
 func NewResponse() *Response {
 	return &Response{
-		Errors: make([]string, 0),
-		Data:   map[string]interface{}{},
+		Version: ProtocolVersion,
+		Type:    ResponseType,
+		Errors:  make([]string, 0),
+		Data:    map[string]interface{}{},
+		Status:  http.StatusOK,
 	}
 }
 
+// NewEvent creates a message pushed to all clients subscribed to the given event
+func NewEvent(event string) *Response {
+	r := NewResponse()
+	r.Type = EventType
+	r.Event = event
+	return r
+}
+
 func (r *Response) Encode() ([]byte, error) {
 	return json.Marshal(r)
 }
 
 func (r *Response) SetError(err error) {
-	r.Errors = append(r.Errors, err.Error())
+	r.SetErrorStr(err.Error())
 }
 
 func (r *Response) SetErrorStr(err string) {
+	r.SetErrorStatus(http.StatusBadRequest, err)
+}
+
+// SetErrorStatus adds an error and sets the http status code used by the api
+func (r *Response) SetErrorStatus(status int, err string) {
 	r.Errors = append(r.Errors, err)
+	if r.Status < status {
+		r.Status = status
+	}
 }
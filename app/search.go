@@ -0,0 +1,393 @@
+package app
+
+import (
+	"errors"
+	"path"
+	"strings"
+	"tbm/scraper"
+	"tbm/search"
+	"tbm/utils/log"
+	"time"
+)
+
+// SearchIndexInterval is the time between two saves of a changed search index
+const SearchIndexInterval = 30 * time.Second
+
+type SearchScope string
+
+const (
+	// BookmarkScope only searches the bookmarked tweets
+	BookmarkScope SearchScope = "bookmark"
+	// ThreadScope additionally searches all thread continuations of the bookmarked tweet author
+	ThreadScope SearchScope = "thread"
+	// AllScope searches every tweet of the conversation including replies and quoted tweets
+	AllScope SearchScope = "all"
+)
+
+const (
+	BookmarkMatch = "bookmark"
+	ThreadMatch   = "thread"
+	ReplyMatch    = "reply"
+	QuoteMatch    = "quote"
+)
+
+// Match describes which tweet inside a bookmarked conversation matched a search query
+type Match struct {
+	TweetId string  `json:"tweet_id"`
+	Kind    string  `json:"kind"`
+	Score   float64 `json:"score"`
+}
+
+//
+// LoadSearchIndex
+// @Description: Load the full-text search index and rebuild it if it's missing or out of sync
+// @receiver a *Application
+// @return error
+func (a *Application) LoadSearchIndex() error {
+	a.index = search.NewIndex(path.Join(a.DataDir, "search.idx"))
+
+	err := a.index.Load()
+	if err != nil && errors.Is(err, search.ErrIndexOutdated) == false {
+		log.Warning("Failed to load the search index: %s", err.Error())
+	}
+	tweets := a.tweets.Snapshot()
+	if err == nil {
+		complete := true
+		for _, ct := range tweets {
+			if a.index.Has(ct.Tweet.IdStr) == false {
+				complete = false
+				break
+			}
+		}
+		if complete {
+			return nil
+		}
+	}
+
+	log.Info("Building search index for %d tweets", len(tweets))
+	a.index.Reset()
+	for _, ct := range tweets {
+		for _, doc := range tweetDocuments(ct) {
+			a.index.Add(doc)
+		}
+	}
+
+	return a.index.Save()
+}
+
+// indexTweet adds a new tweet to the search index. The index is saved by saveSearchIndex in the background.
+func (a *Application) indexTweet(ct *scraper.CachedTweet) {
+	for _, doc := range tweetDocuments(ct) {
+		a.index.Add(doc)
+	}
+}
+
+// saveSearchIndex writes the changed search index every SearchIndexInterval and a last time on shutdown
+func (a *Application) saveSearchIndex() {
+	ticker := time.NewTicker(SearchIndexInterval)
+	defer ticker.Stop()
+	defer close(a.indexSaved)
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-a.indexDone:
+			if err := a.index.Save(); err != nil {
+				log.Error("Failed to save the search index: %s", err.Error())
+			}
+			return
+		}
+		if err := a.index.Save(); err != nil {
+			log.Error("Failed to save the search index: %s", err.Error())
+		}
+	}
+}
+
+// documentId builds the search document id of a tweet inside the conversation of a bookmark
+func documentId(bookmarkId, tweetId string) string {
+	if bookmarkId == tweetId {
+		return bookmarkId
+	}
+	return bookmarkId + "/" + tweetId
+}
+
+func splitDocumentId(id string) (string, string) {
+	parts := strings.SplitN(id, "/", 2)
+	if len(parts) == 1 {
+		return parts[0], parts[0]
+	}
+	return parts[0], parts[1]
+}
+
+// tweetDocuments returns a search document for the bookmarked tweet and each tweet of its conversation
+func tweetDocuments(ct *scraper.CachedTweet) []*search.Document {
+	fields := []string{
+		ct.Tweet.FullText,
+		ct.User.Legacy.ScreenName,
+		ct.User.Legacy.Name,
+	}
+	for _, u := range ct.Tweet.Entities.Urls {
+		fields = append(fields, u.ExpandedUrl)
+	}
+	fields = append(fields, altTexts(&ct.Tweet)...)
+	if tweet, ok := ct.Conversation.GlobalObjects.Tweets[ct.Tweet.IdStr]; ok && len(ct.Tweet.ExtendedEntities.Media) == 0 {
+		fields = append(fields, altTexts(&tweet)...)
+	}
+
+	docs := []*search.Document{{
+		ID:     ct.Tweet.IdStr,
+		Lang:   ct.Tweet.Lang,
+		Fields: fields,
+	}}
+
+	for id, tweet := range ct.Conversation.GlobalObjects.Tweets {
+		if id == ct.Tweet.IdStr {
+			continue
+		}
+		user := ct.Conversation.GetUser(tweet.UserIdStr)
+		fields := []string{
+			tweet.FullText,
+			user.ScreenName,
+			user.Name,
+		}
+		for _, u := range tweet.Entities.Urls {
+			fields = append(fields, u.ExpandedUrl)
+		}
+		fields = append(fields, altTexts(&tweet)...)
+
+		docs = append(docs, &search.Document{
+			ID:     documentId(ct.Tweet.IdStr, id),
+			Lang:   tweet.Lang,
+			Fields: fields,
+		})
+	}
+
+	return docs
+}
+
+func altTexts(tweet *scraper.TweetResult) []string {
+	texts := make([]string, 0)
+	for _, media := range tweet.ExtendedEntities.Media {
+		if media.ExtAltText != "" {
+			texts = append(texts, media.ExtAltText)
+		}
+	}
+	return texts
+}
+
+//
+// matchKind
+// @Description: Determine the relation between a tweet inside the conversation and the bookmarked tweet
+// @param ct *scraper.CachedTweet
+// @param tweetId string
+// @return string
+func matchKind(ct *scraper.CachedTweet, tweetId string) string {
+	if tweetId == ct.Tweet.IdStr {
+		return BookmarkMatch
+	}
+	if ct.Tweet.QuotedStatusIDStr == tweetId {
+		return QuoteMatch
+	}
+	for _, tweet := range ct.Conversation.GlobalObjects.Tweets {
+		if tweet.QuotedStatusIDStr == tweetId {
+			return QuoteMatch
+		}
+	}
+	if tweet, ok := ct.Conversation.GlobalObjects.Tweets[tweetId]; ok && tweet.UserIdStr == ct.User.RestId {
+		return ThreadMatch
+	}
+	return ReplyMatch
+}
+
+func (s SearchScope) includes(kind string) bool {
+	switch s {
+	case AllScope:
+		return true
+	case ThreadScope:
+		return kind == BookmarkMatch || kind == ThreadMatch
+	}
+	return kind == BookmarkMatch
+}
+
+// searchResult contains the bookmarks matching a query ordered by descending relevance
+type searchResult struct {
+	tweets  []*scraper.CachedTweet
+	scores  map[string]float64
+	matches map[string][]Match
+}
+
+//
+// parseSearchScope
+// @Description: Read the search scope from a task payload
+// @param payload map[string]interface{}
+// @return SearchScope
+// @return error
+func parseSearchScope(payload map[string]interface{}) (SearchScope, error) {
+	scope := BookmarkScope
+	if _scope, ok := payload["scope"].(string); ok && _scope != "" {
+		scope = SearchScope(_scope)
+		if scope != BookmarkScope && scope != ThreadScope && scope != AllScope {
+			return scope, errors.New("unknown search scope \"" + _scope + "\" (expected bookmark, thread or all)")
+		}
+	}
+	return scope, nil
+}
+
+//
+// search
+// @Description: Find all bookmarks matching the given query. All bookmarks are returned for an empty query.
+// @receiver a *Application
+// @param query string
+// @param scope SearchScope
+// @return *searchResult
+// @return error
+func (a *Application) search(query string, scope SearchScope) (*searchResult, error) {
+	snapshot := a.tweets.Snapshot()
+	if strings.TrimSpace(query) == "" {
+		return &searchResult{
+			tweets:  snapshot,
+			scores:  map[string]float64{},
+			matches: map[string][]Match{},
+		}, nil
+	}
+
+	q, err := search.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(snapshot))
+	lookup := make(map[string]*scraper.CachedTweet, len(snapshot))
+	for _, tweet := range snapshot {
+		lookup[tweet.Tweet.IdStr] = tweet
+		ids = append(ids, tweet.Tweet.IdStr)
+		if scope != BookmarkScope {
+			for id := range tweet.Conversation.GlobalObjects.Tweets {
+				if id != tweet.Tweet.IdStr && scope.includes(matchKind(tweet, id)) {
+					ids = append(ids, documentId(tweet.Tweet.IdStr, id))
+				}
+			}
+		}
+	}
+
+	results := q.Execute(a.index, ids, func(id string) *search.Target {
+		bookmarkId, tweetId := splitDocumentId(id)
+		ct, ok := lookup[bookmarkId]
+		if ok == false {
+			return nil
+		}
+		if tweetId == bookmarkId {
+			return &search.Target{
+				Tweet:      &ct.Tweet,
+				ScreenName: ct.User.Legacy.ScreenName,
+				Name:       ct.User.Legacy.Name,
+				Source:     ct.Source,
+			}
+		}
+		tweet, ok := ct.Conversation.GlobalObjects.Tweets[tweetId]
+		if ok == false || scope.includes(matchKind(ct, tweetId)) == false {
+			return nil
+		}
+		user := ct.Conversation.GetUser(tweet.UserIdStr)
+		return &search.Target{
+			Tweet:      &tweet,
+			ScreenName: user.ScreenName,
+			Name:       user.Name,
+			Source:     ct.Source,
+		}
+	})
+
+	result := &searchResult{
+		tweets:  make([]*scraper.CachedTweet, 0),
+		scores:  map[string]float64{},
+		matches: map[string][]Match{},
+	}
+	for _, r := range results {
+		bookmarkId, tweetId := splitDocumentId(r.ID)
+		ct := lookup[bookmarkId]
+		if _, ok := result.matches[bookmarkId]; ok == false {
+			result.tweets = append(result.tweets, ct)
+			result.scores[bookmarkId] = r.Score
+		}
+		result.matches[bookmarkId] = append(result.matches[bookmarkId], Match{
+			TweetId: tweetId,
+			Kind:    matchKind(ct, tweetId),
+			Score:   r.Score,
+		})
+	}
+	return result, nil
+}
+
+//
+// selectTweets
+// @Description: Select the tweets matching the query and scope of a payload. The query is optional, sort, order,
+// offset and limit work like in search_tweets.
+// @receiver a *Application
+// @param payload map[string]interface{}
+// @return []*scraper.CachedTweet the selected page
+// @return *ListOptions
+// @return int number of matching tweets before offset and limit have been applied
+// @return error
+func (a *Application) selectTweets(payload map[string]interface{}) ([]*scraper.CachedTweet, *ListOptions, int, error) {
+	scope, err := parseSearchScope(payload)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	opts, err := ParseListOptions(payload, SortRelevance, OrderDesc)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	query, _ := payload["query"].(string)
+	result, err := a.search(query, scope)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if strings.TrimSpace(query) == "" && opts.Sort == SortRelevance {
+		opts.Sort = SortCreatedAt
+	}
+
+	sorted := opts.sort(result.tweets)
+	return opts.page(sorted), opts, len(sorted), nil
+}
+
+func (a *Application) searchTweets(t *Task, r *Response) {
+	if _query, ok := t.Payload["query"]; ok {
+		query, _ := _query.(string)
+
+		scope, err := parseSearchScope(t.Payload)
+		if err != nil {
+			r.SetError(err)
+			return
+		}
+
+		opts, err := ParseListOptions(t.Payload, SortRelevance, OrderDesc)
+		if err != nil {
+			r.SetError(err)
+			return
+		}
+
+		result, err := a.search(query, scope)
+		if err != nil {
+			r.SetError(err)
+			return
+		}
+		if strings.TrimSpace(query) == "" {
+			if opts.Sort == SortRelevance {
+				opts.Sort = SortCreatedAt
+			}
+			opts.Apply(result.tweets, r)
+			return
+		}
+
+		pageScores := map[string]float64{}
+		pageMatches := map[string][]Match{}
+		for _, ct := range opts.Apply(result.tweets, r) {
+			pageScores[ct.Tweet.IdStr] = result.scores[ct.Tweet.IdStr]
+			pageMatches[ct.Tweet.IdStr] = result.matches[ct.Tweet.IdStr]
+		}
+		r.Data["scores"] = pageScores
+		r.Data["matches"] = pageMatches
+	} else {
+		r.SetErrorStr("query parameter not found")
+	}
+}
@@ -1,6 +1,8 @@
 package app
 
 type Task struct {
+	ID      string                 `json:"id"`
+	Version int                    `json:"version"`
 	Command string                 `json:"command"`
 	Payload map[string]interface{} `json:"payload"`
 }
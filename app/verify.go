@@ -0,0 +1,89 @@
+package app
+
+import (
+	"context"
+	"os"
+	"sort"
+	"tbm/utils/log"
+)
+
+// VerifyResult lists all problems found inside the data directory
+type VerifyResult struct {
+	Tweets int `json:"tweets"`
+	Media  int `json:"media"`
+	// Missing media files haven't been downloaded or are empty
+	Missing []MediaFile `json:"missing"`
+	// Repaired media files have been downloaded again
+	Repaired int `json:"repaired"`
+	// Duplicates are bookmark indices used by more than one tweet
+	Duplicates []int `json:"duplicates"`
+}
+
+//
+// Ok
+// @Description: Check whether no problems are left
+// @receiver r *VerifyResult
+// @return bool
+func (r *VerifyResult) Ok() bool {
+	return len(r.Missing) == 0 && len(r.Duplicates) == 0
+}
+
+//
+// Verify
+// @Description: Check that the media files of all stored tweets exist and their bookmark indices are unique
+// @receiver a *Application
+// @param ctx context.Context
+// @param repair bool download missing media files again unless the application runs in offline mode
+// @return *VerifyResult
+// @return error
+func (a *Application) Verify(ctx context.Context, repair bool) (*VerifyResult, error) {
+	tweets := a.tweets.Snapshot()
+	result := &VerifyResult{
+		Tweets:     len(tweets),
+		Missing:    make([]MediaFile, 0),
+		Duplicates: make([]int, 0),
+	}
+
+	indices := map[int]int{}
+	checked := map[string]bool{}
+	for _, ct := range tweets {
+		if ct.Index != 0 {
+			indices[ct.Index]++
+		}
+
+		for _, file := range a.MediaFiles(ct) {
+			if checked[file.Target] {
+				continue
+			}
+			checked[file.Target] = true
+			result.Media++
+
+			if stat, err := os.Stat(file.Target); err == nil && stat.Size() > 0 {
+				continue
+			}
+			if repair && a.Mode == OnlineMode {
+				if ctx.Err() != nil {
+					return result, ctx.Err()
+				}
+				if err := a.Scraper.Download(ctx, file.Source, file.Target); err == nil {
+					log.Success("Media file of tweet %s downloaded: %s", ct.Tweet.IdStr, file.Target)
+					result.Repaired++
+					continue
+				}
+			}
+			log.Warning("Media file of tweet %s missing: %s", ct.Tweet.IdStr, file.Target)
+			result.Missing = append(result.Missing, file)
+		}
+	}
+
+	for index, count := range indices {
+		if count > 1 {
+			log.Warning("Bookmark index %d is used by %d tweets", index, count)
+			result.Duplicates = append(result.Duplicates, index)
+		}
+	}
+	sort.Ints(result.Duplicates)
+
+	log.Statistic("Verified %d tweets and %d media files: %d missing, %d repaired, %d duplicate indices", result.Tweets, result.Media, len(result.Missing), result.Repaired, len(result.Duplicates))
+	return result, nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"tbm/app"
+	"tbm/scraper"
+	"tbm/scraper/twittertest"
+	"testing"
+)
+
+// newTestApplication creates an application with an empty data dir. The scraper is pointed to srv unless it's nil.
+func newTestApplication(t *testing.T, srv *twittertest.Server) *app.Application {
+	t.Helper()
+
+	a := loadTestApplication(t, t.TempDir(), srv)
+	t.Cleanup(func() {
+		_ = a.Shutdown()
+	})
+	return a
+}
+
+// loadTestApplication loads an application using the given data dir. It has to be shut down by the caller.
+func loadTestApplication(t *testing.T, dir string, srv *twittertest.Server) *app.Application {
+	t.Helper()
+
+	a := app.NewApplication(staticFiles)
+	a.DataDir = dir
+	a.ConfigFileName = path.Join(dir, "config.json")
+	if srv != nil {
+		srv.Configure(a.Scraper)
+	}
+	if err := a.Load(); err != nil {
+		t.Fatalf("failed to load the application: %s", err.Error())
+	}
+	return a
+}
+
+func TestSyncWhileQuerying(t *testing.T) {
+	srv := twittertest.NewServer()
+	defer srv.Close()
+	srv.PageSize = 10
+	srv.AddBookmark(twittertest.Tweets(1000, 60)...)
+
+	a := newTestApplication(t, srv)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := a.Scraper.RunOnce(context.Background(), false)
+		done <- err
+	}()
+
+	queries := map[string]map[string]interface{}{
+		"get_tweets":    {"limit": float64(20)},
+		"search_tweets": {"query": "golang", "limit": float64(20)},
+		"get_stats":     {},
+	}
+	for running := true; running; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("sync failed: %s", err.Error())
+			}
+			running = false
+		default:
+		}
+		for command, payload := range queries {
+			if r := a.Execute(command, payload); len(r.Errors) > 0 {
+				t.Fatalf("%s failed: %v", command, r.Errors)
+			}
+		}
+	}
+
+	if r := a.Execute("get_stats", nil); r.Data["tweets"] != 60 {
+		t.Errorf("expected 60 tweets, got %v", r.Data["tweets"])
+	}
+	if r := a.Execute("search_tweets", map[string]interface{}{"query": "golang"}); r.Data["total"] != 60 {
+		t.Errorf("expected 60 search results, got %v", r.Data["total"])
+	}
+}
+
+// importLikes imports a twitter archive containing a like of each given tweet id
+func importLikes(t *testing.T, a *app.Application, ids ...string) {
+	t.Helper()
+
+	likes := make([]string, len(ids))
+	for i, id := range ids {
+		likes[i] = fmt.Sprintf(`{"like": {"tweetId": "%s", "fullText": "liked", "expandedUrl": "https://twitter.com/user0/status/%s"}}`, id, id)
+	}
+
+	archive := path.Join(t.TempDir(), "archive.zip")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := zip.NewWriter(f)
+	w, _ := z.Create("data/like.js")
+	_, _ = w.Write([]byte("window.YTD.like.part0 = [" + strings.Join(likes, ",") + "]"))
+	_ = z.Close()
+	_ = f.Close()
+	if _, err := a.ImportArchive(context.Background(), archive); err != nil {
+		t.Fatalf("import failed: %s", err.Error())
+	}
+}
+
+func TestArchivedTweetBecomesBookmark(t *testing.T) {
+	tweets := twittertest.Tweets(1000, 30)
+	srv := twittertest.NewServer()
+	defer srv.Close()
+	srv.PageSize = 10
+	srv.AddBookmark(tweets[:20]...)
+
+	a := newTestApplication(t, srv)
+	if _, err := a.Scraper.RunOnce(context.Background(), false); err != nil {
+		t.Fatalf("sync failed: %s", err.Error())
+	}
+
+	// A liked tweet which gets bookmarked afterwards
+	importLikes(t, a, "1025")
+
+	srv.AddBookmark(tweets[20:]...)
+	result, err := a.Scraper.RunOnce(context.Background(), false)
+	if err != nil {
+		t.Fatalf("sync failed: %s", err.Error())
+	}
+	if result.New != 10 {
+		t.Errorf("expected 10 new bookmarks, got %d", result.New)
+	}
+	for _, ct := range a.GetTweets() {
+		if ct.Tweet.IdStr == "1025" && ct.Source != scraper.SourceBookmark {
+			t.Errorf("expected the archived tweet to become a bookmark, got source %q", ct.Source)
+		}
+	}
+	if n := len(a.GetTweets()); n != 30 {
+		t.Errorf("expected 30 tweets, got %d", n)
+	}
+}
+
+func TestArchivedTweetsHaveNoBookmarkIndex(t *testing.T) {
+	srv := twittertest.NewServer()
+	defer srv.Close()
+	srv.AddBookmark(twittertest.Tweets(1000, 5)...)
+
+	a := newTestApplication(t, srv)
+	if _, err := a.Scraper.RunOnce(context.Background(), false); err != nil {
+		t.Fatalf("sync failed: %s", err.Error())
+	}
+	indexes := map[string]int{}
+	for _, ct := range a.GetTweets() {
+		indexes[ct.Tweet.IdStr] = ct.Index
+	}
+
+	importLikes(t, a, "2000", "2001")
+	srv.AddBookmark(twittertest.Tweets(2000, 1)...)
+	if _, err := a.Scraper.RunOnce(context.Background(), false); err != nil {
+		t.Fatalf("sync failed: %s", err.Error())
+	}
+
+	for _, ct := range a.GetTweets() {
+		switch id := ct.Tweet.IdStr; {
+		case id == "2001":
+			if ct.Index != 0 {
+				t.Errorf("expected the archived tweet not to have a bookmark index, got %d", ct.Index)
+			}
+		case id == "2000":
+			// The liked tweet became the newest bookmark
+			if ct.Index != app.DefaultBookmarkIndex-6 {
+				t.Errorf("expected the bookmarked like to receive the next index, got %d", ct.Index)
+			}
+		case indexes[id] != ct.Index:
+			t.Errorf("expected bookmark %s to keep index %d, got %d", id, indexes[id], ct.Index)
+		}
+	}
+
+	r := a.Execute("get_tweets", map[string]interface{}{"sort": "index", "order": "asc", "fields": []interface{}{"index"}})
+	tweets, _ := r.Data["tweets"].([]map[string]interface{})
+	if len(tweets) != 7 || tweets[0]["index"] != app.DefaultBookmarkIndex-6 || tweets[6]["index"] != 0 {
+		t.Errorf("expected the archived tweet to follow all bookmarks, got %v", tweets)
+	}
+}
+
+func TestSearchIndexRebuild(t *testing.T) {
+	srv := twittertest.NewServer()
+	defer srv.Close()
+	srv.AddBookmark(twittertest.Tweets(1000, 10)...)
+
+	dir := t.TempDir()
+	a := loadTestApplication(t, dir, srv)
+	if _, err := a.Scraper.RunOnce(context.Background(), false); err != nil {
+		t.Fatalf("sync failed: %s", err.Error())
+	}
+	if err := a.Shutdown(); err != nil {
+		t.Fatalf("failed to shut down: %s", err.Error())
+	}
+
+	// An index which can't be decoded anymore, e.g. written by another version
+	filename := path.Join(dir, "search.idx")
+	if err := os.WriteFile(filename, []byte("outdated"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a = loadTestApplication(t, dir, nil)
+	defer func() {
+		_ = a.Shutdown()
+	}()
+
+	if r := a.Execute("search_tweets", map[string]interface{}{"query": "golang"}); r.Data["total"] != 10 {
+		t.Errorf("expected the rebuilt index to find 10 tweets, got %v", r.Data["total"])
+	}
+	if b, err := os.ReadFile(filename); err != nil || string(b) == "outdated" {
+		t.Errorf("expected the rebuilt index to be saved right away")
+	}
+}
+
+func TestExportSite(t *testing.T) {
+	srv := twittertest.NewServer()
+	defer srv.Close()
+	srv.AddBookmark(twittertest.Tweets(1000, 3)...)
+
+	a := newTestApplication(t, srv)
+	if _, err := a.Scraper.RunOnce(context.Background(), false); err != nil {
+		t.Fatalf("sync failed: %s", err.Error())
+	}
+
+	dir := t.TempDir()
+	n, err := a.ExportSite(dir)
+	if err != nil || n != 3 {
+		t.Fatalf("expected 3 exported tweets, got %d (%v)", n, err)
+	}
+	for _, name := range []string{"index.html", "search-index.js", "thread/1000.html", "thread/1002.html"} {
+		if stat, err := os.Stat(path.Join(dir, name)); err != nil || stat.Size() == 0 {
+			t.Errorf("expected %s to be written", name)
+		}
+	}
+	if entries, err := os.ReadDir(path.Join(dir, "css")); err != nil || len(entries) == 0 {
+		t.Errorf("expected the styles to be copied, got %v", err)
+	}
+
+	b, err := os.ReadFile(path.Join(dir, "search-index.js"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := strings.TrimSpace(string(b))
+	if strings.HasPrefix(script, "window.tbmSearchIndex = ") == false || strings.HasSuffix(script, ";") == false {
+		t.Fatalf("expected the index to be assigned to window.tbmSearchIndex, got %q", script)
+	}
+	var entries []struct {
+		Id   string `json:"id"`
+		User string `json:"user"`
+		Text string `json:"text"`
+		Url  string `json:"url"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSuffix(strings.TrimPrefix(script, "window.tbmSearchIndex = "), ";")), &entries); err != nil {
+		t.Fatalf("failed to decode the search index: %s", err.Error())
+	}
+	// Newest tweets first
+	if len(entries) != 3 || entries[0].Id != "1002" || entries[0].Url != "thread/1002.html" || entries[0].Text != "tweet 1002 about golang" {
+		t.Errorf("expected 3 entries starting with the newest tweet, got %+v", entries)
+	}
+
+	thread, err := os.ReadFile(path.Join(dir, "thread", "1001.html"))
+	if err != nil || strings.Contains(string(thread), "tweet 1001 about golang") == false {
+		t.Errorf("expected the thread page to contain the tweet (%v)", err)
+	}
+	// Thread pages are one level below the index and link the assets relatively
+	if strings.Contains(string(thread), `href="../css/style.css"`) == false || strings.Contains(string(thread), `href="../index.html"`) == false {
+		t.Error("expected the thread page to link the assets and the index relatively")
+	}
+}
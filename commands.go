@@ -0,0 +1,654 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/fatih/color"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"tbm/app"
+	"tbm/scraper"
+	"tbm/server"
+	"tbm/utils/log"
+	"time"
+)
+
+// newCommands lists all commands in the order they are shown by "tbm help"
+func newCommands(a *app.Application) []*command {
+	commands := []*command{
+		serveCommand(a),
+		syncCommand(a),
+		searchCommand(a),
+		statsCommand(a),
+		exportCommand(a),
+		exportSiteCommand(a),
+		exportTableCommand(a),
+		exportBookmarksCommand(a),
+		exportEpubCommand(a),
+		verifyCommand(a),
+		importCommand(a, "import-har", "Import bookmarks from a HAR file saved by the browser", a.ImportHar),
+		importCommand(a, "import-archive", "Import tweets and likes from a twitter data archive", a.ImportArchive),
+		hashPasswordCommand(),
+		versionCommand(),
+	}
+	commands = append(commands, helpCommand(&commands))
+	return commands
+}
+
+// outputFlags registers the flags controlling the log output
+func outputFlags(fs *flag.FlagSet) {
+	fs.IntVar(&log.Mode, "log", log.Mode, "Set the log mode (0 = all, 1 = success, 2 = warning, 3 = statistic, 4 = error)")
+	fs.BoolVar(&color.NoColor, "no-color", color.NoColor, "Disable color output")
+}
+
+// dataFlags registers the flags locating the config file and the fetched data
+func dataFlags(fs *flag.FlagSet, a *app.Application) {
+	outputFlags(fs)
+	fs.StringVar(&a.Timezone, "timezone", a.Timezone, "Application time zone")
+	fs.StringVar(&a.ConfigFileName, "config", a.ConfigFileName, "Application config file")
+	fs.StringVar(&a.DataDir, "data-dir", a.DataDir, "Folder containing all fetched data")
+	fs.StringVar(&a.Storage.Driver, "storage", a.Storage.Driver, "Storage driver (sqlite or json)")
+}
+
+func offlineFlag(fs *flag.FlagSet, a *app.Application) {
+	fs.BoolFunc("offline", "Don't fetch new bookmarks; link to local files only", func(string) error {
+		a.Mode = app.OfflineMode
+		return nil
+	})
+}
+
+func serverFlags(fs *flag.FlagSet, a *app.Application) {
+	fs.StringVar(&a.Server.Host, "host", a.Server.Host, "Host address the api should bind to")
+	fs.UintVar(&a.Server.Port, "port", a.Server.Port, "Port the api should bind to")
+	fs.StringVar(&a.Server.TLS.CertFile, "tls-cert", a.Server.TLS.CertFile, "TLS certificate file")
+	fs.StringVar(&a.Server.TLS.KeyFile, "tls-key", a.Server.TLS.KeyFile, "TLS private key file")
+	fs.BoolVar(&a.Server.TLS.SelfSigned, "tls-self-signed", a.Server.TLS.SelfSigned, "Generate and use a self-signed TLS certificate")
+	fs.StringVar(&a.Server.Auth.Token, "auth-token", a.Server.Auth.Token, "Static api token required to access the server")
+}
+
+func scraperFlags(fs *flag.FlagSet, a *app.Application) {
+	fs.StringVar(&a.Scraper.AccessToken, "access-token", a.Scraper.AccessToken, "Twitter bearer access token")
+	fs.StringVar(&a.Scraper.Cookie, "cookie", a.Scraper.Cookie, "Twitter cookie string")
+	fs.StringVar(&a.Scraper.Sections.Index, "index-section", a.Scraper.Sections.Index, "Twitter bookmark api section name")
+	fs.StringVar(&a.Scraper.Sections.Remove, "remove-section", a.Scraper.Sections.Remove, "Twitter remove bookmark api section name")
+	fs.DurationVar(&a.Scraper.Timeout, "timeout", a.Scraper.Timeout, "Request timeout")
+	fs.StringVar(&a.Scraper.UserAgent, "user-agent", a.Scraper.UserAgent, "User-Agent sent with every request")
+	fs.StringVar(&a.Scraper.Proxy, "proxy", a.Scraper.Proxy, "Route all requests through a http, https or socks5 proxy")
+	fs.StringVar(&a.Scraper.SyncMode, "sync-mode", a.Scraper.SyncMode, "Sync mode (incremental or full)")
+	fs.DurationVar(&a.Scraper.Delay, "delay", a.Scraper.Delay, "Delay your request by a given time")
+	fs.BoolVar(&a.Danger.RemoveBookmarks, "danger-remove-bookmarks", a.Danger.RemoveBookmarks, "Remove the bookmark on Twitter if the tweet has been downloaded")
+}
+
+// load reads the config file and opens the storage
+func load(a *app.Application) bool {
+	if err := a.Load(); err != nil {
+		log.Error("Failed to load the config file: %s", err.Error())
+		return false
+	}
+	return true
+}
+
+// shutdown closes the storage and turns a failed shutdown into an error exit code
+func shutdown(a *app.Application, code int) int {
+	if err := a.Shutdown(); err != nil {
+		log.Error("Failed to shut down: %s", err.Error())
+		if code == exitOk {
+			code = exitError
+		}
+	}
+	return code
+}
+
+// intervalFlag registers the sync interval. It's applied after loading the config file, so it takes precedence.
+func intervalFlag(fs *flag.FlagSet, interval *time.Duration) {
+	fs.DurationVar(interval, "interval", 0, "Time between two syncs (default scraper.interval or "+scraper.FetchInterval.String()+")")
+}
+
+func serveCommand(a *app.Application) *command {
+	var version, hashPassword bool
+	var interval time.Duration
+	return &command{
+		Name:        "serve",
+		Description: "Start the server and sync the bookmarks in the background (default)",
+		Flags: func(fs *flag.FlagSet) {
+			dataFlags(fs, a)
+			serverFlags(fs, a)
+			scraperFlags(fs, a)
+			offlineFlag(fs, a)
+			intervalFlag(fs, &interval)
+			fs.BoolVar(&version, "version", false, "Show version and exit")
+			fs.BoolVar(&hashPassword, "hash-password", false, "Read a password from stdin, print its bcrypt hash and exit")
+		},
+		Run: func(ctx context.Context, args []string) int {
+			if version {
+				return printVersion()
+			}
+			if hashPassword {
+				return printPasswordHash()
+			}
+			if len(args) > 0 {
+				log.Error("Unexpected argument \"%s\"", args[0])
+				return exitError
+			}
+			if load(a) == false {
+				return exitConfig
+			}
+			if interval > 0 {
+				a.Scraper.Interval = interval
+			}
+
+			if err := a.Start(ctx); err != nil {
+				log.Error("Failed to start the application: %s", err.Error())
+				return exitStartFailed
+			}
+			return exitOk
+		},
+	}
+}
+
+func syncCommand(a *app.Application) *command {
+	var once, full bool
+	var interval time.Duration
+	return &command{
+		Name:        "sync",
+		Description: "Sync the bookmarks without starting the server",
+		Flags: func(fs *flag.FlagSet) {
+			dataFlags(fs, a)
+			scraperFlags(fs, a)
+			intervalFlag(fs, &interval)
+			fs.BoolVar(&once, "once", false, "Sync a single time and exit, e.g. when running as cron job or systemd timer")
+			fs.BoolVar(&full, "full", false, "Walk through all bookmarks instead of stopping at the first known one")
+		},
+		Run: func(ctx context.Context, args []string) int {
+			if load(a) == false {
+				return exitConfig
+			}
+			if interval > 0 {
+				a.Scraper.Interval = interval
+			}
+			if full {
+				a.Scraper.SyncMode = scraper.FullSync
+			}
+
+			result, err := a.Sync(ctx, once)
+			code := exitOk
+			if err != nil {
+				log.Error("Failed to sync the bookmarks: %s", err.Error())
+				code = exitError
+			} else if once {
+				code = syncExitCode(result)
+			}
+			return shutdown(a, code)
+		},
+	}
+}
+
+// syncExitCode tells cron jobs and systemd timers whether a single sync succeeded
+func syncExitCode(result *scraper.RunResult) int {
+	switch {
+	case result == nil || errors.Is(result.Err, context.Canceled):
+		return exitInterrupted
+	case result.Err != nil:
+		return exitError
+	case result.Failed > 0:
+		return exitPartial
+	}
+	return exitOk
+}
+
+func searchCommand(a *app.Application) *command {
+	var scope, sortBy, order string
+	var limit int
+	var asJson bool
+	return &command{
+		Name:        "search",
+		Args:        "<query>",
+		Description: "Search the stored tweets using the query syntax of the search api",
+		Flags: func(fs *flag.FlagSet) {
+			// Only the results are printed by default
+			log.Mode = log.LogError
+			dataFlags(fs, a)
+			fs.StringVar(&scope, "scope", string(app.BookmarkScope), "Search scope (bookmark, thread or all)")
+			fs.IntVar(&limit, "limit", 20, "Maximum number of results, 0 shows all")
+			fs.StringVar(&sortBy, "sort", app.SortRelevance, "Sort by relevance, index, created_at, likes or retweets")
+			fs.StringVar(&order, "order", app.OrderDesc, "Sort order (asc or desc)")
+			fs.BoolVar(&asJson, "json", false, "Print the results as json")
+		},
+		Run: func(ctx context.Context, args []string) int {
+			if len(args) == 0 {
+				log.Error("Usage: tbm search <query>")
+				return exitError
+			}
+			if load(a) == false {
+				return exitConfig
+			}
+
+			r := a.Execute("search_tweets", map[string]interface{}{
+				"query":  strings.Join(args, " "),
+				"scope":  scope,
+				"limit":  float64(limit),
+				"sort":   sortBy,
+				"order":  order,
+				"fields": []interface{}{"index", "user", "tweet"},
+			})
+			return shutdown(a, printResponse(r, asJson, printTweets))
+		},
+	}
+}
+
+func statsCommand(a *app.Application) *command {
+	var asJson bool
+	return &command{
+		Name:        "stats",
+		Description: "Show statistics about the stored tweets",
+		Flags: func(fs *flag.FlagSet) {
+			log.Mode = log.LogError
+			dataFlags(fs, a)
+			fs.BoolVar(&asJson, "json", false, "Print the statistics as json")
+		},
+		Run: func(ctx context.Context, args []string) int {
+			if load(a) == false {
+				return exitConfig
+			}
+			r := a.Execute("get_stats", map[string]interface{}{})
+			return shutdown(a, printResponse(r, asJson, printStats))
+		},
+	}
+}
+
+func exportCommand(a *app.Application) *command {
+	var format string
+	return &command{
+		Name:        "export",
+		Args:        "<dir>",
+		Description: "Export all stored tweets",
+		Flags: func(fs *flag.FlagSet) {
+			dataFlags(fs, a)
+			fs.StringVar(&format, "format", app.JsonExport, "Export format ("+strings.Join(app.ExportFormats, ", ")+")")
+		},
+		Run: func(ctx context.Context, args []string) int {
+			if len(args) != 1 {
+				log.Error("Usage: tbm export <dir>")
+				return exitError
+			}
+			if load(a) == false {
+				return exitConfig
+			}
+
+			count, err := a.Export(format, args[0])
+			if err != nil {
+				log.Error("Failed to export into %s: %s", args[0], err.Error())
+				return shutdown(a, exitError)
+			}
+			log.Statistic("%d tweets exported into %s", count, args[0])
+			return shutdown(a, exitOk)
+		},
+	}
+}
+
+func exportSiteCommand(a *app.Application) *command {
+	return &command{
+		Name:        "export-site",
+		Args:        "<dir>",
+		Description: "Export all stored tweets as a static html site",
+		Flags: func(fs *flag.FlagSet) {
+			dataFlags(fs, a)
+		},
+		Run: func(ctx context.Context, args []string) int {
+			if len(args) != 1 {
+				log.Error("Usage: tbm export-site <dir>")
+				return exitError
+			}
+			if load(a) == false {
+				return exitConfig
+			}
+
+			count, err := a.ExportSite(args[0])
+			if err != nil {
+				log.Error("Failed to export into %s: %s", args[0], err.Error())
+				return shutdown(a, exitError)
+			}
+			log.Statistic("%d tweets exported into %s", count, args[0])
+			return shutdown(a, exitOk)
+		},
+	}
+}
+
+// exportQuery contains the search flags shared by all exports of search results
+type exportQuery struct {
+	scope, sortBy, order, output string
+	limit                        int
+}
+
+func exportQueryFlags(fs *flag.FlagSet, q *exportQuery) {
+	fs.StringVar(&q.scope, "scope", string(app.BookmarkScope), "Search scope (bookmark, thread or all)")
+	fs.IntVar(&q.limit, "limit", 0, "Maximum number of tweets, 0 exports all")
+	fs.StringVar(&q.sortBy, "sort", app.SortRelevance, "Sort by relevance, index, created_at, likes or retweets")
+	fs.StringVar(&q.order, "order", app.OrderDesc, "Sort order (asc or desc)")
+	fs.StringVar(&q.output, "o", "", "Write the export into the given file instead of stdout")
+}
+
+// payload builds the search payload of the given query arguments
+func (q *exportQuery) payload(args []string) map[string]interface{} {
+	return map[string]interface{}{
+		"query": strings.Join(args, " "),
+		"scope": q.scope,
+		"limit": float64(q.limit),
+		"sort":  q.sortBy,
+		"order": q.order,
+	}
+}
+
+// write streams an export into the output file or stdout
+func (q *exportQuery) write(a *app.Application, write func(w io.Writer) (int, error)) int {
+	w := io.Writer(os.Stdout)
+	if q.output != "" {
+		file, err := os.Create(q.output)
+		if err != nil {
+			log.Error("Failed to create %s: %s", q.output, err.Error())
+			return shutdown(a, exitError)
+		}
+		defer file.Close()
+		w = file
+	}
+	count, err := write(w)
+	if err != nil {
+		log.Error("Failed to export: %s", err.Error())
+		return shutdown(a, exitError)
+	}
+	if q.output != "" {
+		log.Statistic("%d entries exported into %s", count, q.output)
+	}
+	return shutdown(a, exitOk)
+}
+
+func exportTableCommand(a *app.Application) *command {
+	var format, columns string
+	q := &exportQuery{}
+	return &command{
+		Name:        "export-table",
+		Args:        "[query]",
+		Description: "Export the stored tweets matching a search as csv or json lines",
+		Flags: func(fs *flag.FlagSet) {
+			// The export is written to stdout by default
+			log.Mode = log.LogError
+			dataFlags(fs, a)
+			fs.StringVar(&format, "format", app.CsvExport, "Export format ("+strings.Join(app.TableFormats, ", ")+")")
+			fs.StringVar(&columns, "columns", "", "Comma separated list of columns ("+strings.Join(app.TableColumns, ", ")+")")
+			exportQueryFlags(fs, q)
+		},
+		Run: func(ctx context.Context, args []string) int {
+			if load(a) == false {
+				return exitConfig
+			}
+
+			payload := q.payload(args)
+			payload["format"] = format
+			payload["columns"] = columns
+			e, err := a.NewTableExport(payload)
+			if err != nil {
+				log.Error("Invalid export: %s", err.Error())
+				return shutdown(a, exitError)
+			}
+			return q.write(a, e.Write)
+		},
+	}
+}
+
+func exportBookmarksCommand(a *app.Application) *command {
+	var group string
+	var permalinks bool
+	q := &exportQuery{}
+	return &command{
+		Name:        "export-bookmarks",
+		Args:        "[query]",
+		Description: "Export the links of the stored tweets matching a search as Netscape bookmark file",
+		Flags: func(fs *flag.FlagSet) {
+			// The export is written to stdout by default
+			log.Mode = log.LogError
+			dataFlags(fs, a)
+			fs.StringVar(&group, "group", app.GroupByAuthor, "Create folders by author, hashtag or none")
+			fs.BoolVar(&permalinks, "permalinks", true, "Add the links of the tweets themselves")
+			exportQueryFlags(fs, q)
+		},
+		Run: func(ctx context.Context, args []string) int {
+			if load(a) == false {
+				return exitConfig
+			}
+
+			payload := q.payload(args)
+			payload["group"] = group
+			payload["permalinks"] = permalinks
+			e, err := a.NewBookmarkExport(payload)
+			if err != nil {
+				log.Error("Invalid export: %s", err.Error())
+				return shutdown(a, exitError)
+			}
+			return q.write(a, e.Write)
+		},
+	}
+}
+
+func exportEpubCommand(a *app.Application) *command {
+	var ids, title string
+	var replies bool
+	q := &exportQuery{}
+	return &command{
+		Name:        "export-epub",
+		Args:        "[query]",
+		Description: "Export the threads of the stored tweets matching a search as EPUB book",
+		Flags: func(fs *flag.FlagSet) {
+			// The export is written to stdout by default
+			log.Mode = log.LogError
+			dataFlags(fs, a)
+			fs.StringVar(&ids, "ids", "", "Comma separated list of tweet ids to export instead of a search")
+			fs.StringVar(&title, "title", "Twitter bookmarks", "Title of the book")
+			fs.BoolVar(&replies, "replies", false, "Add the replies of other users to the threads")
+			exportQueryFlags(fs, q)
+		},
+		Run: func(ctx context.Context, args []string) int {
+			if load(a) == false {
+				return exitConfig
+			}
+
+			payload := q.payload(args)
+			payload["ids"] = ids
+			payload["title"] = title
+			payload["replies"] = replies
+			e, err := a.NewEpubExport(payload)
+			if err != nil {
+				log.Error("Invalid export: %s", err.Error())
+				return shutdown(a, exitError)
+			}
+			return q.write(a, e.Write)
+		},
+	}
+}
+
+func verifyCommand(a *app.Application) *command {
+	var repair bool
+	return &command{
+		Name:        "verify",
+		Description: "Check that all media files exist and the bookmark indices are unique",
+		Flags: func(fs *flag.FlagSet) {
+			dataFlags(fs, a)
+			scraperFlags(fs, a)
+			offlineFlag(fs, a)
+			fs.BoolVar(&repair, "repair", false, "Download missing media files again")
+		},
+		Run: func(ctx context.Context, args []string) int {
+			if load(a) == false {
+				return exitConfig
+			}
+
+			result, err := a.Verify(ctx, repair)
+			if err != nil {
+				log.Error("Failed to verify %s: %s", a.DataDir, err.Error())
+				return shutdown(a, exitError)
+			}
+			if result.Ok() == false {
+				return shutdown(a, exitError)
+			}
+			return shutdown(a, exitOk)
+		},
+	}
+}
+
+func importCommand(a *app.Application, name, description string, fn func(ctx context.Context, filename string) (*app.ImportResult, error)) *command {
+	return &command{
+		Name:        name,
+		Args:        "<file>",
+		Description: description,
+		Flags: func(fs *flag.FlagSet) {
+			dataFlags(fs, a)
+			scraperFlags(fs, a)
+			offlineFlag(fs, a)
+		},
+		Run: func(ctx context.Context, args []string) int {
+			if len(args) != 1 {
+				log.Error("Usage: tbm %s <file>", name)
+				return exitError
+			}
+			if load(a) == false {
+				return exitConfig
+			}
+
+			if _, err := fn(ctx, args[0]); err != nil {
+				log.Error("Failed to import %s: %s", args[0], err.Error())
+				return shutdown(a, exitError)
+			}
+			return shutdown(a, exitOk)
+		},
+	}
+}
+
+func hashPasswordCommand() *command {
+	return &command{
+		Name:        "hash-password",
+		Description: "Read a password from stdin and print its bcrypt hash",
+		Run: func(ctx context.Context, args []string) int {
+			return printPasswordHash()
+		},
+	}
+}
+
+func versionCommand() *command {
+	return &command{
+		Name:        "version",
+		Description: "Show the version",
+		Flags:       outputFlags,
+		Run: func(ctx context.Context, args []string) int {
+			return printVersion()
+		},
+	}
+}
+
+func helpCommand(commands *[]*command) *command {
+	return &command{
+		Name:        "help",
+		Args:        "[command]",
+		Description: "Show all commands or the flags of a single command",
+		Run: func(ctx context.Context, args []string) int {
+			if len(args) == 0 {
+				printUsage(*commands)
+				return exitOk
+			}
+			c := findCommand(*commands, args[0])
+			if c == nil {
+				log.Error("Unknown command \"%s\"", args[0])
+				return exitError
+			}
+			newFlagSet(c).Usage()
+			return exitOk
+		},
+	}
+}
+
+func printVersion() int {
+	fmt.Printf("version: %s\nbuild number: %s\n", color.CyanString(buildVersion), color.CyanString(buildNumber))
+	return exitOk
+}
+
+func printPasswordHash() int {
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		log.Error("Failed to read the password: %s", err.Error())
+		return exitError
+	}
+	hash, err := server.HashPassword(strings.TrimRight(password, "\r\n"))
+	if err != nil {
+		log.Error("Failed to hash the password: %s", err.Error())
+		return exitError
+	}
+	fmt.Println(hash)
+	return exitOk
+}
+
+// printResponse prints the data of a response either as json or using the given function
+func printResponse(r *app.Response, asJson bool, print func(data map[string]interface{})) int {
+	if len(r.Errors) > 0 {
+		for _, err := range r.Errors {
+			log.Error("%s", err)
+		}
+		return exitError
+	}
+
+	if asJson {
+		b, err := json.MarshalIndent(r.Data, "", "  ")
+		if err != nil {
+			log.Error("Failed to encode the response: %s", err.Error())
+			return exitError
+		}
+		fmt.Println(string(b))
+		return exitOk
+	}
+	print(r.Data)
+	return exitOk
+}
+
+func printTweets(data map[string]interface{}) {
+	items, _ := data["tweets"].([]map[string]interface{})
+	scores, _ := data["scores"].(map[string]float64)
+	for _, item := range items {
+		index, _ := item["index"].(int)
+		user, _ := item["user"].(scraper.UserResult)
+		tweet, _ := item["tweet"].(scraper.TweetResult)
+
+		fmt.Printf("%s %s %s %s\n",
+			color.CyanString("#%d", index),
+			scraper.ParseTime(tweet.CreatedAt).Format("2006-01-02"),
+			color.GreenString("@%s", user.Legacy.ScreenName),
+			color.New(color.Faint).Sprintf("(score %.2f)", scores[tweet.IdStr]),
+		)
+		for _, line := range strings.Split(tweet.FullText, "\n") {
+			fmt.Printf("    %s\n", line)
+		}
+		fmt.Printf("    %s\n\n", color.BlueString("%s", app.TweetUrl(user.Legacy.ScreenName, tweet.IdStr)))
+	}
+	fmt.Printf("%d of %d results\n", len(items), data["total"])
+}
+
+func printStats(data map[string]interface{}) {
+	for _, key := range []string{"tweets", "users", "conversation_tweets", "media", "oldest", "newest"} {
+		fmt.Printf("%-20s %v\n", strings.ReplaceAll(key, "_", " "), data[key])
+	}
+
+	languages, _ := data["languages"].(map[string]int)
+	names := make([]string, 0, len(languages))
+	for name := range languages {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return languages[names[i]] > languages[names[j]]
+	})
+	for i, name := range names {
+		names[i] = fmt.Sprintf("%s %d", name, languages[name])
+	}
+	fmt.Printf("%-20s %s\n", "languages", strings.Join(names, ", "))
+}
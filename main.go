@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"flag"
 	"fmt"
-	"github.com/fatih/color"
 	"math/rand"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"tbm/app"
 	"tbm/utils/log"
 	"time"
@@ -18,56 +21,134 @@ var staticFiles embed.FS
 var buildNumber string
 var buildVersion string
 
+// Exit codes of all commands
+const (
+	exitOk    = 0
+	exitError = 1
+	// exitConfig is returned if the config file or the storage couldn't be loaded (No such file or directory)
+	exitConfig = 2
+	// exitPartial is returned by "sync --once" if some tweets couldn't be downloaded
+	exitPartial = 3
+	// exitInterrupted is returned if a single sync got interrupted by a signal
+	exitInterrupted = 130
+	// exitStartFailed is returned if the server couldn't be started (State not recoverable)
+	exitStartFailed = 131
+)
+
+// command is a subcommand of the cli, e.g. "tbm sync --once"
+type command struct {
+	Name string
+	// Args describes the positional arguments in the usage line
+	Args        string
+	Description string
+	// Flags registers all flags of the command
+	Flags func(fs *flag.FlagSet)
+	// Run executes the command with the remaining positional arguments and returns the exit code
+	Run func(ctx context.Context, args []string) int
+}
+
 func main() {
 	rand.Seed(time.Now().UTC().UnixNano())
 
 	a := app.NewApplication(staticFiles)
-
-	flag.StringVar(&a.Timezone, "timezone", a.Timezone, "Application time zone")
-	flag.StringVar(&a.ConfigFileName, "config", a.ConfigFileName, "Application config file")
-	flag.StringVar(&a.DataDir, "data-dir", a.DataDir, "Folder containing all fetched data")
-	flag.StringVar(&a.Server.Host, "host", a.Server.Host, "Host address the api should bind to")
-	flag.UintVar(&a.Server.Port, "port", a.Server.Port, "Port the api should bind to")
-	flag.StringVar(&a.Scraper.AccessToken, "access-token", a.Scraper.AccessToken, "Twitter bearer access token")
-	flag.StringVar(&a.Scraper.Cookie, "cookie", a.Scraper.Cookie, "Twitter cookie string")
-	flag.StringVar(&a.Scraper.Sections.Index, "index-section", a.Scraper.Sections.Index, "Twitter bookmark api section name")
-	flag.StringVar(&a.Scraper.Sections.Remove, "remove-section", a.Scraper.Sections.Remove, "Twitter remove bookmark api section name")
-	flag.DurationVar(&a.Scraper.Timeout, "timeout", a.Scraper.Timeout, "Request timeout")
-	flag.DurationVar(&a.Scraper.Delay, "delay", a.Scraper.Delay, "Delay your request by a given time")
-	flag.BoolVar(&a.Danger.RemoveBookmarks, "danger-remove-bookmarks", a.Danger.RemoveBookmarks, "Remove the bookmark on Twitter if the tweet has been downloaded")
-
-	flag.IntVar(&log.Mode, "log", log.Mode, "Set the log mode (0 = all, 1 = success, 2 = warning, 3 = statistic, 4 = error)")
-
-	sv := flag.Bool("version", false, "Show version and exit")
-	nc := flag.Bool("no-color", false, "Disable color output")
-	offline := flag.Bool("offline", false, "Don't fetch new bookmarks; link to local files only")
-	flag.Parse()
-
-	if *nc {
-		color.NoColor = true // disables colorized output
-	}
-
 	a.Build = app.Build{
 		Number:  buildNumber,
 		Version: buildVersion,
 	}
+	commands := newCommands(a)
+
+	// Without a command the server gets started, so existing setups keep working
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && strings.HasPrefix(args[0], "-") == false {
+		name, args = args[0], args[1:]
+	}
+	c := findCommand(commands, name)
+	if c == nil {
+		log.Error("Unknown command \"%s\"", name)
+		printUsage(commands)
+		os.Exit(exitError)
+	}
+
+	fs := newFlagSet(c)
+	args = parseFlags(fs, args)
+
+	// Shut down gracefully on the first signal. A second one terminates the process immediately.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	code := c.Run(ctx, args)
+	stop()
+	os.Exit(code)
+}
+
+func findCommand(commands []*command, name string) *command {
+	for _, c := range commands {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
 
-	if *sv {
-		fmt.Printf("version: %s\nbuild number: %s\n", color.CyanString(buildVersion), color.CyanString(buildNumber))
-		os.Exit(0)
+// newFlagSet creates the flag set of a command, which prints the usage of the command on -h
+func newFlagSet(c *command) *flag.FlagSet {
+	fs := flag.NewFlagSet("tbm "+c.Name, flag.ExitOnError)
+	fs.Usage = func() {
+		usage := "Usage: tbm " + c.Name + " [flags]"
+		if c.Args != "" {
+			usage += " " + c.Args
+		}
+		fmt.Fprintf(fs.Output(), "%s\n\n%s\n", usage, c.Description)
+		if hasFlags(fs) {
+			fmt.Fprintf(fs.Output(), "\nFlags:\n")
+			fs.PrintDefaults()
+		}
 	}
-	if *offline {
-		a.Mode = app.OfflineMode
+	if c.Flags != nil {
+		c.Flags(fs)
 	}
+	return fs
+}
+
+func hasFlags(fs *flag.FlagSet) bool {
+	found := false
+	fs.VisitAll(func(f *flag.Flag) {
+		found = true
+	})
+	return found
+}
 
-	if err := a.Load(); err != nil {
-		log.Error("Failed to load the config file: %s", err.Error())
-		os.Exit(2) // No such file or directory
+// parseFlags parses all flags of the command, even if they follow a positional argument, and returns the
+// positional arguments. Everything after "--" is taken as is.
+func parseFlags(fs *flag.FlagSet, args []string) []string {
+	rest := make([]string, 0)
+	for i, arg := range args {
+		if arg == "--" {
+			args, rest = args[:i], args[i+1:]
+			break
+		}
 	}
 
-	if err := a.Start(); err != nil {
-		log.Error("Failed to start the application: %s", err.Error())
-		os.Exit(131) // State not recoverable
+	positional := make([]string, 0)
+	for {
+		_ = fs.Parse(args) // Exits on error
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
 	}
+	return append(positional, rest...)
+}
 
+func printUsage(commands []*command) {
+	fmt.Fprintf(os.Stderr, "Usage: tbm <command> [flags] [arguments]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", c.Name, c.Description)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun \"tbm help <command>\" to show the flags of a command.\n")
 }
@@ -0,0 +1,311 @@
+package scraper
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// twitterEpoch is the time of the first snowflake id in milliseconds
+	twitterEpoch = 1288834974657
+	// firstSnowflakeId is the first tweet id containing its creation time
+	firstSnowflakeId = 29700859247
+)
+
+var (
+	archiveTweetFile = regexp.MustCompile(`^data/tweets?(-part[0-9]+)?\.js$`)
+	archiveLikeFile  = regexp.MustCompile(`^data/like(-part[0-9]+)?\.js$`)
+	statusUrl        = regexp.MustCompile(`^/([a-zA-Z0-9_]+)/status(es)?/[0-9]+`)
+
+	// archiveNumbers lists all fields the archive stores as strings, although they are numbers
+	archiveNumbers = map[string]bool{
+		"indices":            true,
+		"display_text_range": true,
+		"favorite_count":     true,
+		"retweet_count":      true,
+		"reply_count":        true,
+		"quote_count":        true,
+		"bitrate":            true,
+		"w":                  true,
+		"h":                  true,
+		"width":              true,
+		"height":             true,
+	}
+)
+
+// Archive is an opened twitter data archive
+type Archive struct {
+	// Tweets written by the owner of the archive
+	Tweets []*CachedTweet
+	// Likes only contain the id and text of the liked tweets and the author if it's part of the url
+	Likes []*CachedTweet
+	// User is the owner of the archive
+	User UserResult
+
+	reader *zip.ReadCloser
+	media  map[string]*zip.File
+}
+
+type archiveAccount struct {
+	Account struct {
+		AccountId          string `json:"accountId"`
+		Username           string `json:"username"`
+		AccountDisplayName string `json:"accountDisplayName"`
+		CreatedAt          string `json:"createdAt"`
+	} `json:"account"`
+}
+
+type archiveProfile struct {
+	Profile struct {
+		Description struct {
+			Bio      string `json:"bio"`
+			Website  string `json:"website"`
+			Location string `json:"location"`
+		} `json:"description"`
+		AvatarMediaUrl string `json:"avatarMediaUrl"`
+	} `json:"profile"`
+}
+
+type archiveLike struct {
+	Like struct {
+		TweetId     string `json:"tweetId"`
+		FullText    string `json:"fullText"`
+		ExpandedUrl string `json:"expandedUrl"`
+	} `json:"like"`
+}
+
+//
+// OpenArchive
+// @Description: Open the zip file of a twitter data archive and read all tweets and likes. Call Close once done.
+// @param filename string
+// @return *Archive
+// @return error
+func OpenArchive(filename string) (*Archive, error) {
+	reader, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Archive{
+		Tweets: make([]*CachedTweet, 0),
+		Likes:  make([]*CachedTweet, 0),
+		reader: reader,
+		media:  map[string]*zip.File{},
+	}
+	if err := a.load(); err != nil {
+		_ = reader.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+//
+// Close
+// @Description: Close the underlying zip file
+// @receiver a *Archive
+// @return error
+func (a *Archive) Close() error {
+	return a.reader.Close()
+}
+
+//
+// Media
+// @Description: Get the content of a media file contained in the archive
+// @receiver a *Archive
+// @param src string original url of the media file
+// @return []byte
+// @return bool false if the archive doesn't contain the file
+func (a *Archive) Media(src string) ([]byte, bool) {
+	u, err := url.Parse(src)
+	if err != nil {
+		return nil, false
+	}
+	f, ok := a.media[path.Base(u.Path)]
+	if ok == false {
+		return nil, false
+	}
+	b, err := readZipFile(f)
+	if err != nil {
+		return nil, false
+	}
+	return b, true
+}
+
+func (a *Archive) load() error {
+	found := false
+	for _, f := range a.reader.File {
+		name := strings.TrimPrefix(f.Name, "./")
+		switch {
+		case name == "data/account.js":
+			accounts := make([]archiveAccount, 0)
+			if err := readArchiveFile(f, &accounts); err != nil {
+				return err
+			}
+			if len(accounts) > 0 {
+				account := accounts[0].Account
+				a.User.RestId = account.AccountId
+				a.User.Id = account.AccountId
+				a.User.Legacy.ScreenName = account.Username
+				a.User.Legacy.Name = account.AccountDisplayName
+				if createdAt, err := time.Parse(time.RFC3339, account.CreatedAt); err == nil {
+					a.User.Legacy.CreatedAt = createdAt.UTC().Format(TimeLayout)
+				}
+			}
+		case name == "data/profile.js":
+			profiles := make([]archiveProfile, 0)
+			if err := readArchiveFile(f, &profiles); err != nil {
+				return err
+			}
+			if len(profiles) > 0 {
+				profile := profiles[0].Profile
+				a.User.Legacy.Description = profile.Description.Bio
+				a.User.Legacy.Location = profile.Description.Location
+				a.User.Legacy.Url = profile.Description.Website
+				a.User.Legacy.ProfileImageUrlHttps = profile.AvatarMediaUrl
+			}
+		case strings.HasPrefix(name, "data/tweets_media/") || strings.HasPrefix(name, "data/profile_media/"):
+			// Media files are prefixed with the id of their tweet or user
+			base := path.Base(name)
+			if i := strings.Index(base, "-"); i >= 0 {
+				a.media[base[i+1:]] = f
+			}
+		}
+	}
+
+	for _, f := range a.reader.File {
+		name := strings.TrimPrefix(f.Name, "./")
+		switch {
+		case archiveTweetFile.MatchString(name):
+			found = true
+			items := make([]map[string]json.RawMessage, 0)
+			if err := readArchiveFile(f, &items); err != nil {
+				return err
+			}
+			for _, item := range items {
+				raw, ok := item["tweet"]
+				if ok == false {
+					continue
+				}
+				tweet, err := parseArchiveTweet(raw)
+				if err != nil {
+					return fmt.Errorf("invalid tweet in %s: %s", name, err.Error())
+				}
+				tweet.UserIdStr = a.User.RestId
+				ct := &CachedTweet{
+					User:   a.User,
+					Tweet:  tweet,
+					Source: SourceArchive,
+				}
+				ct.Conversation = ct.StandaloneConversation()
+				a.Tweets = append(a.Tweets, ct)
+			}
+		case archiveLikeFile.MatchString(name):
+			found = true
+			likes := make([]archiveLike, 0)
+			if err := readArchiveFile(f, &likes); err != nil {
+				return err
+			}
+			for _, like := range likes {
+				if like.Like.TweetId == "" {
+					continue
+				}
+				ct := &CachedTweet{
+					Source: SourceArchive,
+				}
+				ct.Tweet.IdStr = like.Like.TweetId
+				ct.Tweet.ConversationIdStr = like.Like.TweetId
+				ct.Tweet.FullText = like.Like.FullText
+				ct.Tweet.CreatedAt = snowflakeTime(like.Like.TweetId)
+				if u, err := url.Parse(like.Like.ExpandedUrl); err == nil {
+					if m := statusUrl.FindStringSubmatch(u.Path); len(m) > 1 && m[1] != "i" {
+						ct.User.Legacy.ScreenName = m[1]
+					}
+				}
+				ct.Conversation = ct.StandaloneConversation()
+				a.Likes = append(a.Likes, ct)
+			}
+		}
+	}
+
+	if found == false {
+		return fmt.Errorf("no tweets or likes found, is this a twitter data archive?")
+	}
+	return nil
+}
+
+// readArchiveFile decodes a javascript file of the archive ("window.YTD.tweets.part0 = [...]")
+func readArchiveFile(f *zip.File, v interface{}) error {
+	b, err := readZipFile(f)
+	if err != nil {
+		return err
+	}
+	if i := bytes.IndexByte(b, '='); i >= 0 && bytes.HasPrefix(bytes.TrimSpace(b), []byte("window.")) {
+		b = b[i+1:]
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("invalid archive file %s: %s", f.Name, err.Error())
+	}
+	return nil
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// parseArchiveTweet converts the numbers stored as strings before decoding the tweet
+func parseArchiveTweet(raw json.RawMessage) (TweetResult, error) {
+	tweet := TweetResult{}
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return tweet, err
+	}
+	b, err := json.Marshal(convertArchiveNumbers(v, false))
+	if err != nil {
+		return tweet, err
+	}
+	err = json.Unmarshal(b, &tweet)
+	return tweet, err
+}
+
+func convertArchiveNumbers(v interface{}, number bool) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			value[key] = convertArchiveNumbers(item, archiveNumbers[key])
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = convertArchiveNumbers(item, number)
+		}
+	case string:
+		if number {
+			if n, err := strconv.Atoi(value); err == nil {
+				return n
+			}
+		}
+	}
+	return v
+}
+
+// snowflakeTime extracts the creation time of a tweet from its id
+func snowflakeTime(id string) string {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || n < firstSnowflakeId {
+		return ""
+	}
+	return time.UnixMilli((n >> 22) + twitterEpoch).UTC().Format(TimeLayout)
+}
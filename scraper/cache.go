@@ -1,8 +1,42 @@
 package scraper
 
+import "time"
+
+const (
+	TimeLayout = "Mon Jan 02 15:04:05 -0700 2006"
+
+	// SourceBookmark is the source of all bookmarks fetched from twitter or imported from a HAR file
+	SourceBookmark = ""
+	// SourceArchive marks tweets and likes imported from a twitter data archive. They aren't bookmarked.
+	SourceArchive = "archive"
+)
+
 type CachedTweet struct {
 	Index        int                  `json:"index"`
 	User         UserResult           `json:"user"`
 	Tweet        TweetResult          `json:"tweet"`
 	Conversation ConversationResponse `json:"conversation"`
+	Source       string               `json:"source,omitempty"`
+}
+
+//
+// StandaloneConversation
+// @Description: Create a conversation only containing the tweet itself, for tweets whose conversation is unknown
+// @receiver ct *CachedTweet
+// @return ConversationResponse
+func (ct *CachedTweet) StandaloneConversation() ConversationResponse {
+	v := ConversationResponse{}
+	v.GlobalObjects.Tweets = map[string]TweetResult{
+		ct.Tweet.IdStr: ct.Tweet,
+	}
+	v.GlobalObjects.Users = map[string]ConversationUser{}
+	if ct.User.RestId != "" {
+		v.GlobalObjects.Users[ct.User.RestId] = ct.User.ConversationUser()
+	}
+	return v
+}
+
+func ParseTime(value string) time.Time {
+	t, _ := time.Parse(TimeLayout, value)
+	return t
 }
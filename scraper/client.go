@@ -0,0 +1,133 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	DefaultUserAgent = "Mozilla/5.0 (X11; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/119.0"
+	DefaultTimeout   = 10 * time.Second
+)
+
+//
+// Load
+// @Description: Load the sync state and create the http clients used for all requests based on the current configuration
+// @receiver s *Scraper
+// @return error
+func (s *Scraper) Load() error {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if s.Proxy != "" {
+		u, err := url.Parse(s.Proxy)
+		if err != nil {
+			return fmt.Errorf("invalid proxy: %s", err.Error())
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("unsupported proxy scheme \"%s\" (expected http, https or socks5)", u.Scheme)
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	var transport http.RoundTripper = &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   timeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	if s.Transport != nil {
+		transport = s.Transport
+	}
+
+	if err := s.loadSyncState(); err != nil {
+		return err
+	}
+	s.scheduler.Delay = s.Delay
+
+	s.clientMx.Lock()
+	defer s.clientMx.Unlock()
+
+	// Api requests have to complete within the timeout, downloads only have to start within it
+	s.client = &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+	}
+	s.downloadClient = &http.Client{
+		Transport: transport,
+	}
+
+	return nil
+}
+
+func (s *Scraper) clients() (*http.Client, *http.Client, error) {
+	s.clientMx.RLock()
+	client, downloadClient := s.client, s.downloadClient
+	s.clientMx.RUnlock()
+
+	if client == nil {
+		if err := s.Load(); err != nil {
+			return nil, nil, err
+		}
+		return s.clients()
+	}
+	return client, downloadClient, nil
+}
+
+// newRequest creates a request carrying the configured user agent
+func (s *Scraper) newRequest(ctx context.Context, method, src string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, src, body)
+	if err != nil {
+		return nil, err
+	}
+	userAgent := s.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+	return req, nil
+}
+
+// authorize adds the twitter credentials to a request
+func (s *Scraper) authorize(req *http.Request) {
+	req.Header.Set("Cookie", s.Cookie)
+	req.Header.Set("authorization", "Bearer "+s.AccessToken)
+	req.Header.Set("x-csrf-token", s.csrfToken)
+}
+
+// do sends a paced api request using the client limited by the configured timeout
+func (s *Scraper) do(req *http.Request) (*http.Response, error) {
+	client, _, err := s.clients()
+	if err != nil {
+		return nil, err
+	}
+	return s.scheduler.Do(client, req, true)
+}
+
+// download sends a request whose response body may take longer than the configured timeout. Downloads share the
+// rate limit budget but aren't delayed.
+func (s *Scraper) download(req *http.Request) (*http.Response, error) {
+	_, client, err := s.clients()
+	if err != nil {
+		return nil, err
+	}
+	return s.scheduler.Do(client, req, false)
+}
@@ -1,5 +1,10 @@
 package scraper
 
+import (
+	"sort"
+	"strings"
+)
+
 type ConversationResponse struct {
 	GlobalObjects struct {
 		Tweets map[string]TweetResult      `json:"tweets"`
@@ -121,3 +126,72 @@ func (c *ConversationResponse) GetUser(userId string) *ConversationUser {
 	}
 	return &ConversationUser{}
 }
+
+//
+// Thread
+// @Description: Return all tweets of the conversation in reply order. Each reply directly follows the tweet it replies to.
+// @receiver c *ConversationResponse
+// @return []TweetResult
+func (c *ConversationResponse) Thread() []TweetResult {
+	children := map[string][]string{}
+	roots := make([]string, 0)
+	for id, tweet := range c.GlobalObjects.Tweets {
+		parent := tweet.InReplyToStatusIDStr
+		if _, ok := c.GlobalObjects.Tweets[parent]; ok && parent != id {
+			children[parent] = append(children[parent], id)
+		} else {
+			roots = append(roots, id)
+		}
+	}
+
+	thread := make([]TweetResult, 0, len(c.GlobalObjects.Tweets))
+	visited := map[string]bool{}
+	var walk func(ids []string)
+	walk = func(ids []string) {
+		sort.Slice(ids, func(i, j int) bool {
+			return CompareIds(ids[i], ids[j]) < 0
+		})
+		for _, id := range ids {
+			if visited[id] {
+				continue
+			}
+			visited[id] = true
+			tweet := c.GlobalObjects.Tweets[id]
+			if tweet.IdStr == "" {
+				tweet.IdStr = id
+			}
+			thread = append(thread, tweet)
+			walk(children[id])
+		}
+	}
+	walk(roots)
+
+	if len(thread) < len(c.GlobalObjects.Tweets) {
+		// Replies forming a cycle don't have a root
+		rest := make([]string, 0)
+		for id := range c.GlobalObjects.Tweets {
+			if visited[id] == false {
+				rest = append(rest, id)
+			}
+		}
+		walk(rest)
+	}
+
+	return thread
+}
+
+//
+// CompareIds
+// @Description: Compare two numeric twitter ids. Twitter ids grow over time, so this is a chronological comparison.
+// @param a string
+// @param b string
+// @return int
+func CompareIds(a, b string) int {
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a, b)
+}
@@ -0,0 +1,279 @@
+package scraper
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// harFile contains the parts of a HTTP Archive (HAR) file required to import bookmarks
+type harFile struct {
+	Log struct {
+		Entries []struct {
+			Request struct {
+				Method string `json:"method"`
+				Url    string `json:"url"`
+			} `json:"request"`
+			Response struct {
+				Status  int `json:"status"`
+				Content struct {
+					MimeType string `json:"mimeType"`
+					Text     string `json:"text"`
+					Encoding string `json:"encoding"`
+				} `json:"content"`
+			} `json:"response"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+// tweetDetailResponse is returned by the TweetDetail GraphQL endpoint used by the web app
+type tweetDetailResponse struct {
+	Data struct {
+		Conversation struct {
+			Instructions []struct {
+				Entries []struct {
+					Content struct {
+						ItemContent timelineTweet `json:"itemContent"`
+						Items       []struct {
+							Item struct {
+								ItemContent timelineTweet `json:"itemContent"`
+							} `json:"item"`
+						} `json:"items"`
+					} `json:"content"`
+				} `json:"entries"`
+			} `json:"instructions"`
+		} `json:"threaded_conversation_with_injections_v2"`
+	} `json:"data"`
+}
+
+type timelineTweet struct {
+	TweetResults struct {
+		Result struct {
+			TweetResultBlock
+			Tweet TweetResultBlock `json:"tweet"`
+		} `json:"result"`
+	} `json:"tweet_results"`
+}
+
+// HarCapture contains all bookmarks, conversations and media files found inside a HAR file
+type HarCapture struct {
+	// Tweets are all bookmarks in the order they have been loaded
+	Tweets []*CachedTweet
+	// Conversations by the id of the tweet they have been loaded for
+	Conversations map[string]*ConversationResponse
+	// Pages is the number of bookmark pages found
+	Pages int
+	// Empty is the number of unavailable bookmarks
+	Empty int
+
+	media map[string][]byte
+}
+
+//
+// ReadHar
+// @Description: Extract all Bookmarks and TweetDetail responses as well as embedded media files from a HAR file
+// saved by the browser's developer tools
+// @param r io.Reader
+// @return *HarCapture
+// @return error
+func ReadHar(r io.Reader) (*HarCapture, error) {
+	har := &harFile{}
+	if err := json.NewDecoder(r).Decode(har); err != nil {
+		return nil, fmt.Errorf("invalid HAR file: %s", err.Error())
+	}
+
+	c := &HarCapture{
+		Tweets:        make([]*CachedTweet, 0),
+		Conversations: map[string]*ConversationResponse{},
+		media:         map[string][]byte{},
+	}
+	seen := map[string]bool{}
+
+	for _, entry := range har.Log.Entries {
+		u, err := url.Parse(entry.Request.Url)
+		if err != nil || entry.Response.Status != 200 || entry.Response.Content.Text == "" {
+			continue
+		}
+		body := []byte(entry.Response.Content.Text)
+		if entry.Response.Content.Encoding == "base64" {
+			if body, err = base64.StdEncoding.DecodeString(entry.Response.Content.Text); err != nil {
+				continue
+			}
+		}
+
+		switch {
+		case strings.Contains(u.Path, "/graphql/") && strings.HasSuffix(u.Path, "/Bookmarks"):
+			rb, err := parseBookmarkResponse(body)
+			if err != nil || len(rb.Errors) > 0 {
+				continue
+			}
+			p := parsePage(rb)
+			c.Pages++
+			c.Empty += p.empty
+			for _, ct := range p.tweets {
+				if seen[ct.Tweet.IdStr] == false {
+					seen[ct.Tweet.IdStr] = true
+					c.Tweets = append(c.Tweets, ct)
+				}
+			}
+		case strings.Contains(u.Path, "/graphql/") && strings.HasSuffix(u.Path, "/TweetDetail"):
+			variables := struct {
+				FocalTweetId string `json:"focalTweetId"`
+			}{}
+			if err := json.Unmarshal([]byte(u.Query().Get("variables")), &variables); err != nil || variables.FocalTweetId == "" {
+				continue
+			}
+			v := &tweetDetailResponse{}
+			if err := json.Unmarshal(body, v); err != nil {
+				continue
+			}
+			c.Conversations[variables.FocalTweetId] = v.conversation()
+		case strings.HasPrefix(u.Path, "/i/api/2/timeline/conversation/"):
+			v := &ConversationResponse{}
+			if err := json.Unmarshal(body, v); err != nil {
+				continue
+			}
+			c.Conversations[strings.TrimSuffix(path.Base(u.Path), ".json")] = v
+		case strings.HasSuffix(u.Host, "twimg.com") && isMediaType(entry.Response.Content.MimeType):
+			// The web app loads several sizes of every image, keep the largest one
+			key := mediaKey(u)
+			if len(body) > len(c.media[key]) {
+				c.media[key] = body
+			}
+		}
+	}
+
+	return c, nil
+}
+
+//
+// Conversation
+// @Description: Get the captured conversation of a bookmark. Falls back to a conversation only containing the
+// bookmarked tweet itself, if it hasn't been opened while capturing.
+// @receiver c *HarCapture
+// @param ct *CachedTweet
+// @return ConversationResponse
+func (c *HarCapture) Conversation(ct *CachedTweet) ConversationResponse {
+	if v, ok := c.Conversations[ct.Tweet.IdStr]; ok {
+		return *v
+	}
+	return ct.StandaloneConversation()
+}
+
+//
+// Media
+// @Description: Get the content of an embedded media file
+// @receiver c *HarCapture
+// @param src string
+// @return []byte
+// @return bool false if the file hasn't been captured
+func (c *HarCapture) Media(src string) ([]byte, bool) {
+	u, err := url.Parse(src)
+	if err != nil {
+		return nil, false
+	}
+	b, ok := c.media[mediaKey(u)]
+	return b, ok
+}
+
+//
+// ConversationUser
+// @Description: Convert the user into the format used by conversations
+// @receiver u UserResult
+// @return ConversationUser
+func (u UserResult) ConversationUser() ConversationUser {
+	cu := ConversationUser{
+		CreatedAt:            u.Legacy.CreatedAt,
+		Description:          u.Legacy.Description,
+		FavouritesCount:      u.Legacy.FavouritesCount,
+		FollowersCount:       u.Legacy.FollowersCount,
+		FriendsCount:         u.Legacy.FriendsCount,
+		IdStr:                u.RestId,
+		ListedCount:          u.Legacy.ListedCount,
+		Name:                 u.Legacy.Name,
+		Location:             u.Legacy.Location,
+		PinnedTweetIdsStr:    u.Legacy.PinnedTweetIdsStr,
+		ProfileBannerUrl:     u.Legacy.ProfileBannerUrl,
+		ProfileImageUrlHttps: u.Legacy.ProfileImageUrlHttps,
+		Protected:            u.Legacy.Protected,
+		ScreenName:           u.Legacy.ScreenName,
+		StatusesCount:        u.Legacy.StatusesCount,
+		Verified:             u.Legacy.Verified,
+	}
+	for _, link := range u.Legacy.Entities.Url.Urls {
+		cu.Entities.Url.Urls = append(cu.Entities.Url.Urls, struct {
+			ExpandedUrl string `json:"expanded_url"`
+		}{ExpandedUrl: link.ExpandedUrl})
+	}
+	return cu
+}
+
+// conversation converts the GraphQL response into the format of the conversation api
+func (v *tweetDetailResponse) conversation() *ConversationResponse {
+	c := &ConversationResponse{}
+	c.GlobalObjects.Tweets = map[string]TweetResult{}
+	c.GlobalObjects.Users = map[string]ConversationUser{}
+
+	add := func(t timelineTweet) {
+		block := t.TweetResults.Result.TweetResultBlock
+		if block.Legacy.IdStr == "" {
+			block = t.TweetResults.Result.Tweet
+		}
+		if block.Legacy.IdStr == "" {
+			return
+		}
+		user := block.Core.UserResults.Result
+		c.GlobalObjects.Tweets[block.Legacy.IdStr] = block.Legacy
+		c.GlobalObjects.Users[user.RestId] = user.ConversationUser()
+	}
+	for _, instruction := range v.Data.Conversation.Instructions {
+		for _, entry := range instruction.Entries {
+			add(entry.Content.ItemContent)
+			for _, item := range entry.Content.Items {
+				add(item.Item.ItemContent)
+			}
+		}
+	}
+	return c
+}
+
+// parseBookmarkResponse also accepts the bookmark_timeline_v2 format used by the current web app
+func parseBookmarkResponse(body []byte) (*BookmarkResponse, error) {
+	rb := &BookmarkResponse{}
+	if err := json.Unmarshal(body, rb); err != nil {
+		return nil, err
+	}
+
+	v2 := struct {
+		Data struct {
+			Timeline json.RawMessage `json:"bookmark_timeline_v2"`
+		} `json:"data"`
+	}{}
+	if err := json.Unmarshal(body, &v2); err == nil && len(v2.Data.Timeline) > 0 {
+		b, err := json.Marshal(map[string]interface{}{
+			"data": map[string]interface{}{
+				"bookmark_timeline": v2.Data.Timeline,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, rb); err != nil {
+			return nil, err
+		}
+	}
+	return rb, nil
+}
+
+// mediaKey identifies a media file independent of the requested format and size
+func mediaKey(u *url.URL) string {
+	return u.Host + strings.TrimSuffix(u.Path, path.Ext(u.Path))
+}
+
+func isMediaType(mimeType string) bool {
+	return strings.HasPrefix(mimeType, "image/") || strings.HasPrefix(mimeType, "video/")
+}
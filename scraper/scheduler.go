@@ -0,0 +1,239 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"tbm/utils/log"
+	"time"
+)
+
+const (
+	DefaultMaxRetries  = 10
+	DefaultBaseBackoff = 5 * time.Second
+	DefaultMaxBackoff  = 15 * time.Minute
+)
+
+// RateLimit describes the current request budget of the scheduler
+type RateLimit struct {
+	// Limit and Remaining as reported by the last x-rate-limit-* headers (-1 = unknown)
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	Reset     time.Time `json:"reset"`
+	// BackoffUntil is set while requests are paused after failures
+	BackoffUntil time.Time `json:"backoff_until"`
+	Failures     int       `json:"failures"`
+	Requests     int       `json:"requests"`
+}
+
+// Scheduler paces all requests sharing a single rate limit budget and retries failed requests using a jittered
+// exponential backoff.
+type Scheduler struct {
+	Delay       time.Duration
+	MaxRetries  int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+
+	OnChange func(state RateLimit)
+
+	state       RateLimit
+	lastRequest time.Time
+	mx          sync.Mutex
+}
+
+//
+// NewScheduler
+// @Description: Create a new Scheduler instance with an unknown budget
+// @param delay time.Duration minimum time between two paced requests
+// @return *Scheduler
+func NewScheduler(delay time.Duration) *Scheduler {
+	return &Scheduler{
+		Delay:       delay,
+		MaxRetries:  DefaultMaxRetries,
+		BaseBackoff: DefaultBaseBackoff,
+		MaxBackoff:  DefaultMaxBackoff,
+		OnChange:    func(state RateLimit) {},
+		state: RateLimit{
+			Limit:     -1,
+			Remaining: -1,
+		},
+	}
+}
+
+//
+// State
+// @Description: Get a copy of the current rate limit state
+// @receiver s *Scheduler
+// @return RateLimit
+func (s *Scheduler) State() RateLimit {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return s.state
+}
+
+//
+// Wait
+// @Description: Block until the next request may be sent and reserve it
+// @receiver s *Scheduler
+// @param ctx context.Context
+// @param paced bool respect the delay between requests
+// @return error
+func (s *Scheduler) Wait(ctx context.Context, paced bool) error {
+	for {
+		s.mx.Lock()
+		now := time.Now()
+		next := now
+		if paced && s.lastRequest.IsZero() == false && s.lastRequest.Add(s.Delay).After(next) {
+			next = s.lastRequest.Add(s.Delay)
+		}
+		if s.state.BackoffUntil.After(next) {
+			next = s.state.BackoffUntil
+		}
+		if s.state.Remaining == 0 && s.state.Reset.After(next) {
+			next = s.state.Reset
+		}
+		if next.After(now) == false {
+			if paced {
+				s.lastRequest = now
+			}
+			if s.state.Remaining > 0 {
+				s.state.Remaining--
+			}
+			s.state.Requests++
+			s.mx.Unlock()
+			return nil
+		}
+		s.mx.Unlock()
+
+		select {
+		case <-time.After(next.Sub(now)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+//
+// Update
+// @Description: Read the rate limit headers of a response and reset the failure counter on success
+// @receiver s *Scheduler
+// @param resp *http.Response
+func (s *Scheduler) Update(resp *http.Response) {
+	s.mx.Lock()
+	if limit, err := strconv.Atoi(resp.Header.Get("x-rate-limit-limit")); err == nil {
+		s.state.Limit = limit
+	}
+	if remaining, err := strconv.Atoi(resp.Header.Get("x-rate-limit-remaining")); err == nil {
+		s.state.Remaining = remaining
+	}
+	if reset, err := strconv.ParseInt(resp.Header.Get("x-rate-limit-reset"), 10, 64); err == nil {
+		s.state.Reset = time.Unix(reset, 0)
+	}
+	if resp.StatusCode < 400 {
+		s.state.Failures = 0
+	}
+	state := s.state
+	s.mx.Unlock()
+
+	s.OnChange(state)
+}
+
+//
+// Backoff
+// @Description: Pause all requests after a failure. The pause doubles with every consecutive failure.
+// @receiver s *Scheduler
+// @param until time.Time pause at least until the given time (e.g. the rate limit reset)
+// @return time.Duration the pause
+func (s *Scheduler) Backoff(until time.Time) time.Duration {
+	s.mx.Lock()
+	s.state.Failures++
+
+	d := s.MaxBackoff
+	if s.state.Failures <= 20 {
+		if exp := s.BaseBackoff << uint(s.state.Failures-1); exp < d {
+			d = exp
+		}
+	}
+	// Full jitter on the upper half prevents synchronized retries
+	d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+
+	now := time.Now()
+	if until.After(now.Add(d)) {
+		d = until.Sub(now)
+	}
+	s.state.BackoffUntil = now.Add(d)
+	state := s.state
+	s.mx.Unlock()
+
+	s.OnChange(state)
+	return d
+}
+
+//
+// Do
+// @Description: Send a request once the budget allows it. Rate limited (429) and failed (5xx) requests as well as
+// network errors are retried after a backoff.
+// @receiver s *Scheduler
+// @param client *http.Client
+// @param req *http.Request
+// @param paced bool respect the delay between requests
+// @return *http.Response
+// @return error
+func (s *Scheduler) Do(client *http.Client, req *http.Request, paced bool) (*http.Response, error) {
+	ctx := req.Context()
+	var lastErr error
+
+	for attempt := 0; attempt <= s.MaxRetries; attempt++ {
+		if err := s.Wait(ctx, paced); err != nil {
+			return nil, err
+		}
+
+		r := req
+		if attempt > 0 {
+			r = req.Clone(ctx)
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				r.Body = body
+			}
+		}
+
+		resp, err := client.Do(r)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			lastErr = err
+			d := s.Backoff(time.Time{})
+			log.Warning("twitter: %s, retrying in %s", err.Error(), d.Round(time.Second))
+			continue
+		}
+
+		s.Update(resp)
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			lastErr = fmt.Errorf("request failed with \"%s\"", resp.Status)
+			// Drain the body, so the connection can be reused
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+
+			until := time.Time{}
+			if resp.StatusCode == http.StatusTooManyRequests {
+				until = s.State().Reset
+			}
+			d := s.Backoff(until)
+			log.Warning("twitter: %s from %s, retrying in %s", lastErr.Error(), req.URL.Host, d.Round(time.Second))
+			continue
+		}
+
+		return resp, nil
+	}
+
+	return nil, fmt.Errorf("giving up after %d attempts: %s", s.MaxRetries+1, lastErr.Error())
+}
@@ -0,0 +1,358 @@
+package scraper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"tbm/utils/log"
+	"time"
+)
+
+const (
+	// MaxPageAttempts limits how often a single bookmark page is requested before the sync gives up
+	MaxPageAttempts = 10
+	// MaxTweetAttempts limits how often a page gets processed again if some of its tweets failed
+	MaxTweetAttempts = 2
+)
+
+// TweetStatus is returned by OnNewTweet
+type TweetStatus int
+
+const (
+	// TweetNew has been downloaded successfully
+	TweetNew TweetStatus = iota
+	// TweetSkipped has already been downloaded before
+	TweetSkipped
+	// TweetFailed couldn't be downloaded and might be retried
+	TweetFailed
+	// TweetRemoved has been downloaded successfully and removed from the bookmarks afterwards
+	TweetRemoved
+)
+
+// downloaded reports whether the tweet has been downloaded during this run
+func (t TweetStatus) downloaded() bool {
+	return t == TweetNew || t == TweetRemoved
+}
+
+// PageState is the step of the sync loop
+type PageState int
+
+const (
+	// PageFetch requests the bookmark page at the current cursor
+	PageFetch PageState = iota
+	// PageProcess hands all tweets of the fetched page to OnNewTweet
+	PageProcess
+	// PageAdvance reports the progress and decides where to continue
+	PageAdvance
+	// PageDone ends the sync
+	PageDone
+)
+
+// Progress describes a single processed bookmark page
+type Progress struct {
+	Page    int    `json:"page"`
+	Cursor  string `json:"cursor"`
+	Tweets  int    `json:"tweets"`
+	New     int    `json:"new"`
+	Skipped int    `json:"skipped"`
+	Failed  int    `json:"failed"`
+	Removed int    `json:"removed"`
+	Empty   int    `json:"empty"`
+}
+
+// RunResult summarizes a single sync run
+type RunResult struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Pages      int       `json:"pages"`
+	New        int       `json:"new"`
+	Skipped    int       `json:"skipped"`
+	Failed     int       `json:"failed"`
+	Removed    int       `json:"removed"`
+	Empty      int       `json:"empty"`
+	// Complete is set if the sync reached the end of the bookmarks or the first known bookmark
+	Complete bool   `json:"complete"`
+	Err      error  `json:"-"`
+	Error    string `json:"error,omitempty"`
+}
+
+// apiError is returned by twitter inside a successful response and is worth retrying
+type apiError struct {
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+// page contains the parsed content of a single bookmark page
+type page struct {
+	tweets []*CachedTweet
+	cursor string
+	empty  int
+}
+
+//
+// Run
+// @Description: Sync the bookmarks unless another run is still active. Blocks until the run has finished.
+// @receiver s *Scraper
+// @param keepCursor bool stay on the first page as long as it contains new bookmarks (used if bookmarks get removed)
+// @return *RunResult nil if another run is active or the scraper has been stopped
+func (s *Scraper) Run(keepCursor bool) *RunResult {
+	s.mx.Lock()
+	if s.running || s.ctx.Err() != nil {
+		s.mx.Unlock()
+		return nil
+	}
+	s.running = true
+	s.wg.Add(1)
+	s.mx.Unlock()
+
+	defer func() {
+		s.mx.Lock()
+		s.running = false
+		s.mx.Unlock()
+		s.wg.Done()
+	}()
+
+	return s.syncBookmarks(s.ctx, keepCursor)
+}
+
+// syncBookmarks walks through the bookmark pages until the end, the first known bookmark or a fatal error
+func (s *Scraper) syncBookmarks(ctx context.Context, keepCursor bool) *RunResult {
+	result := &RunResult{StartedAt: time.Now()}
+	cursor := s.beginSync()
+
+	state := PageFetch
+	fetchAttempts, tweetAttempts := 0, 0
+	var current *page
+	var progress *Progress
+	var outcomes map[string]TweetStatus
+
+	for state != PageDone {
+		if ctx.Err() != nil {
+			log.Info("Scraper stopped")
+			result.Err = ctx.Err()
+			break
+		}
+
+		switch state {
+		case PageFetch:
+			p, err := s.fetchPage(ctx, cursor)
+			var apiErr *apiError
+			if errors.As(err, &apiErr) && fetchAttempts+1 < MaxPageAttempts {
+				fetchAttempts++
+				d := s.scheduler.Backoff(time.Time{})
+				log.Warning("twitter: api error at cursor \"%s\" with %s, retrying in %s", cursor, err.Error(), d.Round(time.Second))
+				continue
+			}
+			if err != nil {
+				result.Err = err
+				state = PageDone
+				continue
+			}
+			if tweetAttempts == 0 {
+				outcomes = map[string]TweetStatus{}
+			}
+			current = p
+			state = PageProcess
+
+		case PageProcess:
+			known := false
+			for _, ct := range current.tweets {
+				if ctx.Err() != nil {
+					break
+				}
+				if s.Known(ct.Tweet.IdStr) {
+					known = true
+				}
+				status := s.OnNewTweet(ctx, ct)
+				// A tweet downloaded during a previous attempt is reported as skipped now
+				if previous, ok := outcomes[ct.Tweet.IdStr]; ok == false || previous.downloaded() == false {
+					outcomes[ct.Tweet.IdStr] = status
+				}
+			}
+			if ctx.Err() != nil {
+				continue
+			}
+
+			progress = &Progress{
+				Page:   result.Pages + 1,
+				Cursor: current.cursor,
+				Tweets: len(current.tweets),
+				Empty:  current.empty,
+			}
+			for _, status := range outcomes {
+				switch status {
+				case TweetNew:
+					progress.New++
+				case TweetRemoved:
+					progress.New++
+					progress.Removed++
+				case TweetSkipped:
+					progress.Skipped++
+				case TweetFailed:
+					progress.Failed++
+				}
+			}
+
+			if progress.Failed > 0 && tweetAttempts+1 < MaxTweetAttempts {
+				tweetAttempts++
+				log.Warning("%d tweets of page %d failed, fetching the page again", progress.Failed, progress.Page)
+				state = PageFetch
+				continue
+			}
+
+			// Stop at the first known bookmark, unless all bookmarks have to be walked through
+			if known && s.full == false && keepCursor == false {
+				current.cursor = ""
+			}
+			state = PageAdvance
+
+		case PageAdvance:
+			result.Pages++
+			result.New += progress.New
+			result.Skipped += progress.Skipped
+			result.Failed += progress.Failed
+			result.Removed += progress.Removed
+			result.Empty += progress.Empty
+			s.OnProgress(progress)
+
+			next := current.cursor
+			done := next == ""
+			entries := len(current.tweets) + current.empty
+			if keepCursor {
+				if size, ok := s.variables["count"].(int); ok && entries < size {
+					// A page which isn't full is the last one
+					done = true
+				} else if progress.New > 0 {
+					// The downloaded bookmarks have been removed, so the same page contains the next ones now.
+					// Pages without new bookmarks are skipped, otherwise they would be fetched forever.
+					next = cursor
+				}
+			} else if entries == 0 {
+				// Twitter keeps returning a bottom cursor once the end has been reached
+				done = true
+			}
+			if done {
+				next = ""
+			}
+
+			s.saveSyncState(func(state *SyncState) {
+				state.Pages = result.Pages
+				state.Tweets = result.New + result.Skipped + result.Failed
+				state.Empty = result.Empty
+				if s.full && keepCursor == false {
+					state.Cursor = next
+				}
+			})
+
+			fetchAttempts, tweetAttempts = 0, 0
+			if done {
+				result.Complete = true
+				state = PageDone
+				continue
+			}
+			cursor = next
+			state = PageFetch
+		}
+	}
+
+	result.FinishedAt = time.Now()
+	if result.Err != nil {
+		result.Error = result.Err.Error()
+		if errors.Is(result.Err, context.Canceled) == false {
+			s.error("%s", result.Error)
+		}
+		log.Statistic("Sync aborted: %d pages, %d new, %d skipped, %d failed, %d removed, %d unavailable", result.Pages, result.New, result.Skipped, result.Failed, result.Removed, result.Empty)
+	} else {
+		s.completeSync(result)
+	}
+	return result
+}
+
+//
+// fetchPage
+// @Description: Request and parse the bookmark page at the given cursor
+// @receiver s *Scraper
+// @param ctx context.Context
+// @param cursor string
+// @return *page
+// @return error *apiError if twitter responded with an error message
+func (s *Scraper) fetchPage(ctx context.Context, cursor string) (*page, error) {
+	s.variables["cursor"] = cursor
+
+	req, err := s.newRequest(ctx, "GET", s.buildUrl(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("client: error making http request: %s", err.Error())
+	}
+	s.authorize(req)
+
+	res, err := s.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("client: error sending http request: %s", err.Error())
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("twitter: failed to fetch response body: %s", res.Status)
+	}
+
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("client: could not read response body: %s", err)
+	}
+
+	rb := &BookmarkResponse{}
+	if err := json.Unmarshal(resBody, rb); err != nil {
+		return nil, fmt.Errorf("client: could not unmarshal response body: %s", err)
+	}
+
+	if len(rb.Errors) > 0 {
+		return nil, &apiError{message: rb.Errors[0].Message}
+	}
+
+	return parsePage(rb), nil
+}
+
+func parsePage(rb *BookmarkResponse) *page {
+	p := &page{
+		tweets: make([]*CachedTweet, 0),
+	}
+	for _, instruction := range rb.Data.BookmarkTimeline.Timeline.Instructions {
+		for _, entry := range instruction.Entries {
+			switch entry.Content.EntryType {
+			case "TimelineTimelineItem":
+				// Tweet
+				tweet := entry.Content.ItemContent.TweetResults.Result.Legacy
+				user := entry.Content.ItemContent.TweetResults.Result.Core.UserResults.Result
+				if tweet.IdStr == "" {
+					tweet = entry.Content.ItemContent.TweetResults.Result.Tweet.Legacy
+					user = entry.Content.ItemContent.TweetResults.Result.Tweet.Core.UserResults.Result
+				}
+
+				if tweet.IdStr == "" {
+					log.Info("Empty tweet id. Probably got deleted at some point")
+					// @TODO: might want to call
+					// 		  s.DeleteBookmarkDetail(entry.Content.ItemContent.TweetResults.Result.RestId)
+					//		  to delete this bookmark - but it might also be a twitter issue and the tweet becomes
+					//		  available at a later point. I'm assuming RestId equals IdStr, but I could be wrong..
+					p.empty++
+				} else {
+					p.tweets = append(p.tweets, &CachedTweet{
+						User:  user,
+						Tweet: tweet,
+					})
+				}
+			case "TimelineTimelineCursor":
+				//Cursor
+				if entry.Content.CursorType == "Bottom" {
+					p.cursor = entry.Content.Value
+				}
+			}
+		}
+	}
+	return p
+}
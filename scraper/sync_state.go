@@ -0,0 +1,145 @@
+package scraper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"tbm/utils/log"
+	"time"
+)
+
+const (
+	// IncrementalSync stops at the first page containing an already downloaded bookmark
+	IncrementalSync = "incremental"
+	// FullSync walks through all bookmarks on every run
+	FullSync = "full"
+
+	// SyncStateFile is stored inside the data dir. It mustn't end with .json, which is reserved for tweets of the
+	// json storage.
+	SyncStateFile = "sync.state"
+)
+
+// SyncState is persisted after every bookmark page, so an interrupted full sync can be resumed
+type SyncState struct {
+	// Cursor of the next page of an unfinished full sync
+	Cursor string `json:"cursor"`
+	// Complete is set once all bookmarks have been walked through at least once
+	Complete    bool      `json:"complete"`
+	LastRun     time.Time `json:"last_run"`
+	LastSuccess time.Time `json:"last_success"`
+	// Pages, Tweets and Empty count the bookmarks seen during the last run
+	Pages     int    `json:"pages"`
+	Tweets    int    `json:"tweets"`
+	Empty     int    `json:"empty"`
+	LastError string `json:"last_error"`
+}
+
+// syncStore reads and writes the SyncState file
+type syncStore struct {
+	filename string
+	state    SyncState
+	mx       sync.RWMutex
+}
+
+func (s *syncStore) load() error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.state = SyncState{}
+	if s.filename == "" {
+		return nil
+	}
+	b, err := os.ReadFile(s.filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, &s.state)
+}
+
+// update modifies the state and writes it atomically
+func (s *syncStore) update(fn func(state *SyncState)) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	fn(&s.state)
+	if s.filename == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.filename + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.filename)
+}
+
+func (s *syncStore) get() SyncState {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	return s.state
+}
+
+//
+// SyncState
+// @Description: Get the state of the current or last sync
+// @receiver s *Scraper
+// @return SyncState
+func (s *Scraper) SyncState() SyncState {
+	return s.sync.get()
+}
+
+func (s *Scraper) loadSyncState() error {
+	if s.SyncMode != IncrementalSync && s.SyncMode != FullSync {
+		return fmt.Errorf("unknown sync mode \"%s\" (expected %s or %s)", s.SyncMode, IncrementalSync, FullSync)
+	}
+	s.sync.filename = s.StateFile
+	return s.sync.load()
+}
+
+// saveSyncState updates the persisted sync state. Failures are only logged, since they don't affect the sync itself.
+func (s *Scraper) saveSyncState(fn func(state *SyncState)) {
+	if err := s.sync.update(fn); err != nil {
+		log.Warning("Failed to save the sync state: %s", err.Error())
+	}
+}
+
+// beginSync decides whether the next run has to walk through all bookmarks and returns the cursor it starts at
+func (s *Scraper) beginSync() string {
+	state := s.sync.get()
+	s.full = s.SyncMode == FullSync || state.Complete == false
+
+	cursor := ""
+	if s.full && state.Cursor != "" {
+		log.Info("Resuming the previous sync at cursor \"%s\"", state.Cursor)
+		cursor = state.Cursor
+	}
+
+	s.saveSyncState(func(state *SyncState) {
+		state.LastRun = time.Now()
+		state.Pages = 0
+		state.Tweets = 0
+		state.Empty = 0
+		state.LastError = ""
+	})
+	return cursor
+}
+
+// completeSync marks the current run as successfully finished
+func (s *Scraper) completeSync(result *RunResult) {
+	s.saveSyncState(func(state *SyncState) {
+		state.Cursor = ""
+		if s.full {
+			state.Complete = true
+		}
+		state.LastSuccess = time.Now()
+	})
+	log.Statistic("Sync finished: %d pages, %d new, %d skipped, %d failed, %d removed, %d unavailable", result.Pages, result.New, result.Skipped, result.Failed, result.Removed, result.Empty)
+}
@@ -0,0 +1,193 @@
+package scraper_test
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"sync"
+	"tbm/scraper"
+	"tbm/scraper/twittertest"
+	"testing"
+	"time"
+)
+
+// syncRecorder collects the ids of all tweets handed to OnNewTweet
+type syncRecorder struct {
+	ids   []string
+	known map[string]bool
+	mx    sync.Mutex
+}
+
+func (r *syncRecorder) onNewTweet(ctx context.Context, ct *scraper.CachedTweet) scraper.TweetStatus {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if r.known[ct.Tweet.IdStr] {
+		return scraper.TweetSkipped
+	}
+	r.known[ct.Tweet.IdStr] = true
+	r.ids = append(r.ids, ct.Tweet.IdStr)
+	return scraper.TweetNew
+}
+
+func (r *syncRecorder) isKnown(id string) bool {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	return r.known[id]
+}
+
+// newTestScraper creates a scraper pointed to srv with a shortened backoff
+func newTestScraper(t *testing.T, srv *twittertest.Server) (*scraper.Scraper, *syncRecorder) {
+	t.Helper()
+
+	r := &syncRecorder{known: map[string]bool{}}
+	s := scraper.NewScraper()
+	srv.Configure(s)
+	s.StateFile = path.Join(t.TempDir(), scraper.SyncStateFile)
+	s.OnNewTweet = r.onNewTweet
+	s.Known = r.isKnown
+	s.Scheduler().BaseBackoff = time.Millisecond
+	s.Scheduler().MaxBackoff = 10 * time.Millisecond
+	if err := s.Load(); err != nil {
+		t.Fatalf("failed to load the scraper: %s", err.Error())
+	}
+	t.Cleanup(s.Stop)
+	return s, r
+}
+
+func runOnce(t *testing.T, s *scraper.Scraper) *scraper.RunResult {
+	t.Helper()
+
+	result, err := s.RunOnce(context.Background(), false)
+	if err != nil {
+		t.Fatalf("failed to start the sync: %s", err.Error())
+	}
+	if result == nil || result.Err != nil {
+		t.Fatalf("sync failed: %+v", result)
+	}
+	return result
+}
+
+func TestSyncPagination(t *testing.T) {
+	srv := twittertest.NewServer()
+	defer srv.Close()
+	srv.PageSize = 10
+	srv.AddBookmark(twittertest.Tweets(100, 25)...)
+
+	s, r := newTestScraper(t, srv)
+	result := runOnce(t, s)
+
+	if result.New != 25 || result.Complete == false {
+		t.Errorf("expected a complete sync with 25 new bookmarks, got %+v", result)
+	}
+	// Three pages of bookmarks and the empty page marking the end
+	if hits := srv.Hits(twittertest.EndpointBookmarks); hits != 4 {
+		t.Errorf("expected 4 page requests, got %d", hits)
+	}
+	if len(r.ids) != 25 || r.ids[0] != "124" || r.ids[24] != "100" {
+		t.Errorf("expected the bookmarks from newest to oldest, got %v", r.ids)
+	}
+}
+
+func TestSyncBackoff(t *testing.T) {
+	srv := twittertest.NewServer()
+	defer srv.Close()
+	srv.AddBookmark(twittertest.Tweets(100, 5)...)
+	srv.Fail(twittertest.EndpointBookmarks, http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusInternalServerError)
+
+	// Without rate limit headers a 429 only waits for the backoff instead of the reset in 15 minutes
+	srv.RateLimit = 0
+	s, r := newTestScraper(t, srv)
+	result := runOnce(t, s)
+
+	if result.New != 5 || len(r.ids) != 5 {
+		t.Errorf("expected 5 new bookmarks after the retries, got %+v", result)
+	}
+	if hits := srv.Hits(twittertest.EndpointBookmarks); hits != 5 {
+		t.Errorf("expected 3 failed and 2 successful page requests, got %d", hits)
+	}
+	if state := s.RateLimit(); state.Failures != 0 {
+		t.Errorf("expected the failures to be reset after a success, got %d", state.Failures)
+	}
+}
+
+func TestSyncGivesUp(t *testing.T) {
+	srv := twittertest.NewServer()
+	defer srv.Close()
+	srv.AddBookmark(twittertest.Tweets(100, 5)...)
+
+	s, _ := newTestScraper(t, srv)
+	s.Scheduler().MaxRetries = 2
+	srv.Fail(twittertest.EndpointBookmarks, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+
+	result, err := s.RunOnce(context.Background(), false)
+	if err != nil {
+		t.Fatalf("failed to start the sync: %s", err.Error())
+	}
+	if result.Err == nil || result.Complete {
+		t.Errorf("expected the sync to fail after 3 attempts, got %+v", result)
+	}
+}
+
+func TestSyncIncrementalStopsAtKnownBookmark(t *testing.T) {
+	srv := twittertest.NewServer()
+	defer srv.Close()
+	srv.PageSize = 10
+	srv.AddBookmark(twittertest.Tweets(100, 30)...)
+
+	s, r := newTestScraper(t, srv)
+	runOnce(t, s)
+
+	srv.AddBookmark(twittertest.Tweets(200, 5)...)
+	hits := srv.Hits(twittertest.EndpointBookmarks)
+	result := runOnce(t, s)
+
+	if result.New != 5 || result.Skipped != 5 {
+		t.Errorf("expected 5 new and 5 skipped bookmarks, got %+v", result)
+	}
+	if pages := srv.Hits(twittertest.EndpointBookmarks) - hits; pages != 1 {
+		t.Errorf("expected the sync to stop after the first page, got %d pages", pages)
+	}
+	if len(r.ids) != 35 {
+		t.Errorf("expected 35 bookmarks, got %d", len(r.ids))
+	}
+
+	// A full sync walks through all pages anyway
+	s.SyncMode = scraper.FullSync
+	hits = srv.Hits(twittertest.EndpointBookmarks)
+	result = runOnce(t, s)
+	if result.Skipped != 35 || srv.Hits(twittertest.EndpointBookmarks)-hits != 5 {
+		t.Errorf("expected the full sync to skip all 35 bookmarks on 5 pages, got %+v", result)
+	}
+}
+
+func TestDeleteBookmark(t *testing.T) {
+	srv := twittertest.NewServer()
+	defer srv.Close()
+	srv.AddBookmark(twittertest.Tweets(100, 3)...)
+
+	s, _ := newTestScraper(t, srv)
+	if err := s.LoadSections(context.Background()); err != nil {
+		t.Fatalf("failed to load the api sections: %s", err.Error())
+	}
+
+	r, err := s.DeleteBookmarkDetail(context.Background(), "101")
+	if err != nil {
+		t.Fatalf("failed to delete the bookmark: %s", err.Error())
+	}
+	if r.Data.TweetBookmarkDelete != "Done" {
+		t.Errorf("expected the bookmark to be deleted, got %q", r.Data.TweetBookmarkDelete)
+	}
+	if ids := srv.Bookmarks(); len(ids) != 2 || ids[0] != "102" || ids[1] != "100" {
+		t.Errorf("expected 2 remaining bookmarks, got %v", ids)
+	}
+
+	r, err = s.DeleteBookmarkDetail(context.Background(), "101")
+	if err != nil {
+		t.Fatalf("failed to delete the bookmark again: %s", err.Error())
+	}
+	if r.Data.TweetBookmarkDelete == "Done" {
+		t.Error("expected a removed bookmark not to be deleted twice")
+	}
+}
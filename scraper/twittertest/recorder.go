@@ -0,0 +1,220 @@
+package twittertest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Mode of a Recorder
+type Mode int
+
+const (
+	// Replay answers all requests using the recorded fixtures and fails on unknown requests
+	Replay Mode = iota
+	// Record sends all requests and stores their responses as fixtures
+	Record
+)
+
+// Fixture is a single recorded response
+type Fixture struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   string      `json:"body"`
+	// Encoding is "base64" for binary bodies
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// Recorder is a http.RoundTripper capturing real responses into fixtures and replaying them offline. Requests are
+// identified by their method, url and body. Repeated identical requests are stored as a sequence and replayed in the
+// same order, the last response is repeated once the sequence is exhausted.
+//
+// Only responses get stored and the Set-Cookie header is dropped, so fixtures never contain the credentials.
+// Recorded bodies may still contain personal data of the recorded account, so review them before sharing.
+type Recorder struct {
+	Dir  string
+	Mode Mode
+	// Transport sends the requests while recording. Defaults to http.DefaultTransport.
+	Transport http.RoundTripper
+
+	calls map[string]int
+	mx    sync.Mutex
+}
+
+var fixtureName = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+//
+// NewRecorder
+// @Description: Create a new Recorder storing its fixtures inside the given directory
+// @param dir string
+// @param mode Mode
+// @return *Recorder
+func NewRecorder(dir string, mode Mode) *Recorder {
+	return &Recorder{
+		Dir:   dir,
+		Mode:  mode,
+		calls: map[string]int{},
+	}
+}
+
+//
+// RoundTrip
+// @Description: Record or replay a single request
+// @receiver r *Recorder
+// @param req *http.Request
+// @return *http.Response
+// @return error
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = b
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	key := fixtureKey(req, body)
+	r.mx.Lock()
+	if r.calls == nil {
+		r.calls = map[string]int{}
+	}
+	call := r.calls[key]
+	r.calls[key]++
+	r.mx.Unlock()
+
+	if r.Mode == Record {
+		return r.record(req, key, call)
+	}
+	return r.replay(req, key, call)
+}
+
+func (r *Recorder) record(req *http.Request, key string, call int) (*http.Response, error) {
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	header := resp.Header.Clone()
+	header.Del("Set-Cookie")
+	f := &Fixture{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Status: resp.StatusCode,
+		Header: header,
+		Body:   string(body),
+	}
+	if utf8.Valid(body) == false {
+		f.Body = base64.StdEncoding.EncodeToString(body)
+		f.Encoding = "base64"
+	}
+
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(r.Dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(r.filename(key, call), b, 0644); err != nil {
+		return nil, err
+	}
+
+	return f.response(req)
+}
+
+func (r *Recorder) replay(req *http.Request, key string, call int) (*http.Response, error) {
+	for ; call >= 0; call-- {
+		b, err := os.ReadFile(r.filename(key, call))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		f := &Fixture{}
+		if err := json.Unmarshal(b, f); err != nil {
+			return nil, fmt.Errorf("twittertest: invalid fixture %s: %s", r.filename(key, call), err.Error())
+		}
+		return f.response(req)
+	}
+	return nil, fmt.Errorf("twittertest: no fixture recorded for %s %s", req.Method, req.URL.String())
+}
+
+// filename of the n-th response to a request
+func (r *Recorder) filename(key string, call int) string {
+	return filepath.Join(r.Dir, key+"-"+strconv.Itoa(call)+".json")
+}
+
+// fixtureKey identifies a request by its method, url and body. The last path element keeps the fixtures readable.
+func fixtureKey(req *http.Request, body []byte) string {
+	query := req.URL.Query()
+	h := sha256.New()
+	h.Write([]byte(req.Method + " " + req.URL.Host + req.URL.Path + "?" + query.Encode() + "\n"))
+	h.Write(body)
+
+	name := strings.TrimSuffix(filepath.Base(req.URL.Path), filepath.Ext(req.URL.Path))
+	name = strings.Trim(fixtureName.ReplaceAllString(name, "_"), "_")
+	if len(name) > 32 {
+		name = name[:32]
+	}
+	return strings.ToLower(req.Method) + "-" + name + "-" + hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// response creates the http response described by the fixture
+func (f *Fixture) response(req *http.Request) (*http.Response, error) {
+	body := []byte(f.Body)
+	if f.Encoding == "base64" {
+		b, err := base64.StdEncoding.DecodeString(f.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = b
+	}
+
+	header := f.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Del("Content-Encoding")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	return &http.Response{
+		Status:        strconv.Itoa(f.Status) + " " + http.StatusText(f.Status),
+		StatusCode:    f.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
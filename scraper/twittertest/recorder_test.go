@@ -0,0 +1,99 @@
+package twittertest
+
+import (
+	"context"
+	"os"
+	"path"
+	"tbm/scraper"
+	"testing"
+)
+
+// syncIds runs a single sync including the conversations and returns the ids of all tweets
+func syncIds(t *testing.T, s *scraper.Scraper) []string {
+	t.Helper()
+
+	ids := make([]string, 0)
+	s.StateFile = path.Join(t.TempDir(), scraper.SyncStateFile)
+	s.OnNewTweet = func(ctx context.Context, ct *scraper.CachedTweet) scraper.TweetStatus {
+		conversation, err := s.TweetDetail(ctx, ct.Tweet.IdStr)
+		if err != nil {
+			return scraper.TweetFailed
+		}
+		for id := range conversation.GlobalObjects.Tweets {
+			ids = append(ids, id)
+		}
+		return scraper.TweetNew
+	}
+	if err := s.Load(); err != nil {
+		t.Fatalf("failed to load the scraper: %s", err.Error())
+	}
+	defer s.Stop()
+
+	result, err := s.RunOnce(context.Background(), false)
+	if err != nil {
+		t.Fatalf("failed to start the sync: %s", err.Error())
+	}
+	if result.Err != nil || result.Failed > 0 {
+		t.Fatalf("sync failed: %+v", result)
+	}
+	return ids
+}
+
+func TestRecorderRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	srv := NewServer()
+	srv.AddBookmark(
+		Tweet{ID: "1", Text: "first", User: User{ID: "10", ScreenName: "gopher"}},
+		Tweet{ID: "2", Text: "second", User: User{ID: "10", ScreenName: "gopher"}, Replies: []Tweet{
+			{ID: "3", Text: "reply", User: User{ID: "11", ScreenName: "other"}},
+		}},
+	)
+
+	recording := scraper.NewScraper()
+	srv.Configure(recording)
+	recording.Transport = NewRecorder(dir, Record)
+	recorded := syncIds(t, recording)
+	srv.Close()
+
+	if len(recorded) != 3 {
+		t.Fatalf("expected 3 recorded tweets, got %v", recorded)
+	}
+	if files, _ := os.ReadDir(dir); len(files) == 0 {
+		t.Fatal("expected recorded fixtures")
+	}
+
+	// The server is gone, so all responses have to come from the fixtures
+	replaying := scraper.NewScraper()
+	srv.Configure(replaying)
+	replaying.Transport = NewRecorder(dir, Replay)
+	replayed := syncIds(t, replaying)
+
+	if len(replayed) != len(recorded) {
+		t.Fatalf("expected the replay to return %v, got %v", recorded, replayed)
+	}
+	seen := map[string]bool{}
+	for _, id := range recorded {
+		seen[id] = true
+	}
+	for _, id := range replayed {
+		if seen[id] == false {
+			t.Errorf("unexpected tweet %s in the replay", id)
+		}
+	}
+}
+
+func TestRecorderUnknownRequest(t *testing.T) {
+	s := scraper.NewScraper()
+	s.BaseURL = "http://twittertest.invalid"
+	s.Transport = NewRecorder(t.TempDir(), Replay)
+	// Missing fixtures are reported like network errors, which would be retried otherwise
+	s.Scheduler().MaxRetries = 0
+	s.StateFile = path.Join(t.TempDir(), scraper.SyncStateFile)
+	if err := s.Load(); err != nil {
+		t.Fatalf("failed to load the scraper: %s", err.Error())
+	}
+
+	if err := s.LoadSections(context.Background()); err == nil {
+		t.Error("expected replaying an unknown request to fail")
+	}
+}
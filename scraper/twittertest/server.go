@@ -0,0 +1,601 @@
+package twittertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"tbm/scraper"
+	"time"
+)
+
+const (
+	EndpointBookmarksPage  = "bookmarks_page"
+	EndpointMainJs         = "main_js"
+	EndpointBookmarks      = "bookmarks"
+	EndpointTweetDetail    = "tweet_detail"
+	EndpointDeleteBookmark = "delete_bookmark"
+	EndpointMedia          = "media"
+
+	DefaultAccessToken      = "AAAAAAAAAAAAAAAAAAAAAtwittertest%3Dtoken"
+	DefaultCsrfToken        = "twittertest-csrf"
+	DefaultBookmarksQueryId = "twittertestBookmarks"
+	DefaultDeleteQueryId    = "twittertestDeleteBookmark"
+	DefaultRateLimit        = 500
+	mainJsHash              = "twittertest"
+	cursorPrefix            = "twittertest-cursor-"
+)
+
+// access levels of the endpoints
+const (
+	public = iota
+	cookie
+	api
+)
+
+// User is the author of a fake tweet
+type User struct {
+	ID         string
+	ScreenName string
+	Name       string
+}
+
+// Media is a photo or video attached to a fake tweet
+type Media struct {
+	ID string
+	// Type is either "photo" or "video"
+	Type    string
+	AltText string
+	// Data is served for the photo (or the video variant of a video). Defaults to a few placeholder bytes.
+	Data []byte
+}
+
+// Tweet is a single fake bookmark
+type Tweet struct {
+	ID        string
+	Text      string
+	Lang      string
+	CreatedAt time.Time
+	User      User
+	Media     []Media
+	// Replies are returned as part of the conversation
+	Replies []Tweet
+	// Unavailable bookmarks are listed without any content, like deleted tweets
+	Unavailable bool
+}
+
+//
+// Tweets
+// @Description: Create count tweets with consecutive ids, the first one being the oldest. The tweets are written by
+// five different users.
+// @param first int id of the first tweet
+// @param count int
+// @return []Tweet
+func Tweets(first, count int) []Tweet {
+	tweets := make([]Tweet, count)
+	for i := range tweets {
+		id := first + i
+		tweets[i] = Tweet{
+			ID:        strconv.Itoa(id),
+			Text:      fmt.Sprintf("tweet %d about golang", id),
+			Lang:      "en",
+			CreatedAt: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(id) * time.Minute),
+			User: User{
+				ID:         strconv.Itoa(1 + id%5),
+				ScreenName: fmt.Sprintf("user%d", id%5),
+				Name:       fmt.Sprintf("User %d", id%5),
+			},
+		}
+	}
+	return tweets
+}
+
+// Server is an in-process fake of the twitter endpoints used by the scraper
+type Server struct {
+	URL string
+
+	AccessToken      string
+	CsrfToken        string
+	BookmarksQueryId string
+	DeleteQueryId    string
+	// PageSize overrides the page size requested by the scraper if set
+	PageSize int
+	// RateLimit is the request budget reported by the x-rate-limit-* headers. It never gets exhausted.
+	RateLimit int
+
+	server    *httptest.Server
+	bookmarks []Tweet
+	tweets    map[string]Tweet
+	failures  map[string][]int
+	hits      map[string]int
+	requests  int
+	mx        sync.Mutex
+}
+
+//
+// NewServer
+// @Description: Start a new fake twitter server. Call Close once done.
+// @return *Server
+func NewServer() *Server {
+	s := &Server{
+		AccessToken:      DefaultAccessToken,
+		CsrfToken:        DefaultCsrfToken,
+		BookmarksQueryId: DefaultBookmarksQueryId,
+		DeleteQueryId:    DefaultDeleteQueryId,
+		RateLimit:        DefaultRateLimit,
+		tweets:           map[string]Tweet{},
+		failures:         map[string][]int{},
+		hits:             map[string]int{},
+	}
+	s.server = httptest.NewServer(s)
+	s.URL = s.server.URL
+	return s
+}
+
+//
+// Close
+// @Description: Shut down the server and close all connections
+// @receiver s *Server
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+//
+// Cookie
+// @Description: Get a cookie accepted by the server
+// @receiver s *Server
+// @return string
+func (s *Server) Cookie() string {
+	return "auth_token=twittertest; ct0=" + s.CsrfToken
+}
+
+//
+// Configure
+// @Description: Point a scraper to the server. Has to be called before the scraper gets loaded.
+// @receiver s *Server
+// @param sc *scraper.Scraper
+func (s *Server) Configure(sc *scraper.Scraper) {
+	sc.BaseURL = s.URL
+	sc.Cookie = s.Cookie()
+	sc.Proxy = ""
+	sc.Delay = 0
+	sc.LoadCsrfToken()
+}
+
+//
+// AddBookmark
+// @Description: Bookmark the given tweets. New bookmarks are listed first, just like on twitter.
+// @receiver s *Server
+// @param tweets ...Tweet
+func (s *Server) AddBookmark(tweets ...Tweet) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	for _, t := range tweets {
+		s.bookmarks = append([]Tweet{t}, s.bookmarks...)
+		s.tweets[t.ID] = t
+	}
+}
+
+//
+// Bookmarks
+// @Description: Get the ids of all current bookmarks
+// @receiver s *Server
+// @return []string
+func (s *Server) Bookmarks() []string {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	ids := make([]string, 0, len(s.bookmarks))
+	for _, t := range s.bookmarks {
+		ids = append(ids, t.ID)
+	}
+	return ids
+}
+
+//
+// Fail
+// @Description: Answer the next requests of an endpoint with the given status codes. http.StatusOK results in a
+// GraphQL error response.
+// @receiver s *Server
+// @param endpoint string one of the Endpoint* constants
+// @param statuses ...int
+func (s *Server) Fail(endpoint string, statuses ...int) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.failures[endpoint] = append(s.failures[endpoint], statuses...)
+}
+
+//
+// Hits
+// @Description: Get the number of requests an endpoint received
+// @receiver s *Server
+// @param endpoint string one of the Endpoint* constants
+// @return int
+func (s *Server) Hits(endpoint string) int {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return s.hits[endpoint]
+}
+
+//
+// ServeHTTP
+// @Description: Route a request to the matching fake endpoint
+// @receiver s *Server
+// @param w http.ResponseWriter
+// @param r *http.Request
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Path
+	switch {
+	case p == "/i/bookmarks":
+		s.serve(w, r, EndpointBookmarksPage, cookie, s.bookmarksPage)
+	case p == "/responsive-web/client-web-legacy/main."+mainJsHash+".js":
+		s.serve(w, r, EndpointMainJs, public, s.mainJs)
+	case p == "/i/api/graphql/"+s.BookmarksQueryId+"/Bookmarks":
+		s.serve(w, r, EndpointBookmarks, api, s.bookmarksTimeline)
+	case p == "/i/api/graphql/"+s.DeleteQueryId+"/DeleteBookmark" && r.Method == http.MethodPost:
+		s.serve(w, r, EndpointDeleteBookmark, api, s.deleteBookmark)
+	case strings.HasPrefix(p, "/i/api/2/timeline/conversation/") && strings.HasSuffix(p, ".json"):
+		s.serve(w, r, EndpointTweetDetail, api, s.conversation)
+	case strings.HasPrefix(p, "/media/"):
+		s.serve(w, r, EndpointMedia, public, s.media)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serve counts the request, applies queued failures and the authentication before calling the handler
+func (s *Server) serve(w http.ResponseWriter, r *http.Request, endpoint string, access int, handler http.HandlerFunc) {
+	s.mx.Lock()
+	s.hits[endpoint]++
+	s.requests++
+	status := 0
+	if queue := s.failures[endpoint]; len(queue) > 0 {
+		status = queue[0]
+		s.failures[endpoint] = queue[1:]
+	}
+	limit, requests := s.RateLimit, s.requests
+	s.mx.Unlock()
+
+	if access == api && limit > 0 {
+		w.Header().Set("x-rate-limit-limit", strconv.Itoa(limit))
+		w.Header().Set("x-rate-limit-remaining", strconv.Itoa(limit-requests%limit))
+		w.Header().Set("x-rate-limit-reset", strconv.FormatInt(time.Now().Add(15*time.Minute).Unix(), 10))
+	}
+
+	switch {
+	case status == http.StatusOK:
+		writeJson(w, http.StatusOK, graphQLError("Internal server error"))
+		return
+	case status != 0:
+		writeJson(w, status, graphQLError(http.StatusText(status)))
+		return
+	}
+
+	if access >= cookie && strings.Contains(r.Header.Get("Cookie"), "ct0="+s.CsrfToken) == false {
+		writeJson(w, http.StatusForbidden, graphQLError("Missing or invalid cookie"))
+		return
+	}
+	if access == api {
+		if r.Header.Get("authorization") != "Bearer "+s.AccessToken || r.Header.Get("x-csrf-token") != s.CsrfToken {
+			writeJson(w, http.StatusForbidden, graphQLError("Bad authentication data"))
+			return
+		}
+	}
+
+	handler(w, r)
+}
+
+func (s *Server) bookmarksPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = fmt.Fprintf(w, `<!DOCTYPE html><html><head><script src="%s/responsive-web/client-web-legacy/main.%s.js" nonce=""></script></head><body></body></html>`, s.URL, mainJsHash)
+}
+
+func (s *Server) mainJs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/javascript")
+	_, _ = fmt.Fprintf(w, `const a="%s";e.exports={queryId:"%s",operationName:"Bookmarks",operationType:"query"};e.exports={queryId:"%s",operationName:"DeleteBookmark",operationType:"mutation"};`,
+		s.AccessToken, s.BookmarksQueryId, s.DeleteQueryId)
+}
+
+func (s *Server) bookmarksTimeline(w http.ResponseWriter, r *http.Request) {
+	variables := struct {
+		Count  int    `json:"count"`
+		Cursor string `json:"cursor"`
+	}{}
+	if err := json.Unmarshal([]byte(r.URL.Query().Get("variables")), &variables); err != nil {
+		writeJson(w, http.StatusBadRequest, graphQLError("invalid variables"))
+		return
+	}
+
+	offset := 0
+	if variables.Cursor != "" {
+		o, err := strconv.Atoi(strings.TrimPrefix(variables.Cursor, cursorPrefix))
+		if err != nil || strings.HasPrefix(variables.Cursor, cursorPrefix) == false {
+			writeJson(w, http.StatusOK, graphQLError("invalid cursor"))
+			return
+		}
+		offset = o
+	}
+	size := variables.Count
+	if s.PageSize > 0 {
+		size = s.PageSize
+	}
+	if size <= 0 {
+		size = 20
+	}
+
+	s.mx.Lock()
+	end := offset + size
+	if offset > len(s.bookmarks) {
+		offset = len(s.bookmarks)
+	}
+	if end > len(s.bookmarks) {
+		end = len(s.bookmarks)
+	}
+	page := append([]Tweet{}, s.bookmarks[offset:end]...)
+	s.mx.Unlock()
+
+	entries := make([]interface{}, 0, len(page)+2)
+	for _, t := range page {
+		result := map[string]interface{}{
+			"__typename": "TweetUnavailable",
+		}
+		if t.Unavailable == false {
+			result = map[string]interface{}{
+				"__typename": "Tweet",
+				"rest_id":    t.ID,
+				"core": map[string]interface{}{
+					"user_results": map[string]interface{}{
+						"result": s.userResult(t.User),
+					},
+				},
+				"legacy": s.tweetLegacy(t),
+			}
+		}
+		entries = append(entries, map[string]interface{}{
+			"entryId":   "tweet-" + t.ID,
+			"sortIndex": t.ID,
+			"content": map[string]interface{}{
+				"entryType":  "TimelineTimelineItem",
+				"__typename": "TimelineTimelineItem",
+				"itemContent": map[string]interface{}{
+					"itemType":   "TimelineTweet",
+					"__typename": "TimelineTweet",
+					"tweet_results": map[string]interface{}{
+						"result": result,
+					},
+					"tweetDisplayType": "Tweet",
+				},
+			},
+		})
+	}
+	for _, c := range []struct {
+		Type  string
+		Value string
+	}{
+		{"Top", cursorPrefix + "0"},
+		{"Bottom", cursorPrefix + strconv.Itoa(end)},
+	} {
+		entries = append(entries, map[string]interface{}{
+			"entryId":   "cursor-" + strings.ToLower(c.Type),
+			"sortIndex": "0",
+			"content": map[string]interface{}{
+				"entryType":  "TimelineTimelineCursor",
+				"__typename": "TimelineTimelineCursor",
+				"value":      c.Value,
+				"cursorType": c.Type,
+			},
+		})
+	}
+
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"data": map[string]interface{}{
+			"bookmark_timeline": map[string]interface{}{
+				"timeline": map[string]interface{}{
+					"instructions": []interface{}{
+						map[string]interface{}{
+							"type":    "TimelineAddEntries",
+							"entries": entries,
+						},
+					},
+				},
+			},
+		},
+	})
+}
+
+func (s *Server) conversation(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/i/api/2/timeline/conversation/"), ".json")
+
+	s.mx.Lock()
+	t, ok := s.tweets[id]
+	s.mx.Unlock()
+	if ok == false || t.Unavailable {
+		writeJson(w, http.StatusNotFound, graphQLError("No status found with that ID."))
+		return
+	}
+
+	tweets := map[string]interface{}{}
+	users := map[string]interface{}{}
+	var add func(t Tweet, parent string)
+	add = func(t Tweet, parent string) {
+		legacy := s.tweetLegacy(t)
+		legacy["in_reply_to_status_id_str"] = parent
+		tweets[t.ID] = legacy
+		users[t.User.ID] = s.conversationUser(t.User)
+		for _, reply := range t.Replies {
+			add(reply, t.ID)
+		}
+	}
+	add(t, "")
+
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"globalObjects": map[string]interface{}{
+			"tweets": tweets,
+			"users":  users,
+		},
+		"timeline": map[string]interface{}{
+			"instructions": []interface{}{},
+		},
+	})
+}
+
+func (s *Server) deleteBookmark(w http.ResponseWriter, r *http.Request) {
+	body := struct {
+		Variables struct {
+			TweetId string `json:"tweet_id"`
+		} `json:"variables"`
+	}{}
+	b, _ := io.ReadAll(r.Body)
+	if err := json.Unmarshal(b, &body); err != nil || body.Variables.TweetId == "" {
+		writeJson(w, http.StatusBadRequest, graphQLError("invalid variables"))
+		return
+	}
+
+	s.mx.Lock()
+	removed := false
+	for i, t := range s.bookmarks {
+		if t.ID == body.Variables.TweetId {
+			s.bookmarks = append(s.bookmarks[:i], s.bookmarks[i+1:]...)
+			removed = true
+			break
+		}
+	}
+	s.mx.Unlock()
+
+	result := ""
+	if removed {
+		result = "Done"
+	}
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"data": map[string]interface{}{
+			"tweet_bookmark_delete": result,
+		},
+	})
+}
+
+func (s *Server) media(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/media/")
+	id := name[:len(name)-len(extension(name))]
+
+	s.mx.Lock()
+	var data []byte
+	found := false
+	for _, t := range s.tweets {
+		if t.User.ID == id {
+			found = true
+		}
+		for _, m := range t.Media {
+			if m.ID == id {
+				data, found = m.Data, true
+			}
+		}
+	}
+	s.mx.Unlock()
+
+	if found == false {
+		http.NotFound(w, r)
+		return
+	}
+	if data == nil {
+		data = []byte("twittertest " + name)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	_, _ = w.Write(data)
+}
+
+func (s *Server) userResult(u User) map[string]interface{} {
+	return map[string]interface{}{
+		"__typename": "User",
+		"id":         u.ID,
+		"rest_id":    u.ID,
+		"legacy": map[string]interface{}{
+			"name":                    u.Name,
+			"screen_name":             u.ScreenName,
+			"profile_image_url_https": s.URL + "/media/" + u.ID + ".jpg",
+		},
+	}
+}
+
+func (s *Server) conversationUser(u User) map[string]interface{} {
+	return map[string]interface{}{
+		"id_str":                  u.ID,
+		"name":                    u.Name,
+		"screen_name":             u.ScreenName,
+		"profile_image_url_https": s.URL + "/media/" + u.ID + ".jpg",
+	}
+}
+
+func (s *Server) tweetLegacy(t Tweet) map[string]interface{} {
+	createdAt := t.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Date(2022, 11, 21, 12, 0, 0, 0, time.UTC)
+	}
+	lang := t.Lang
+	if lang == "" {
+		lang = "en"
+	}
+
+	media := make([]interface{}, 0, len(t.Media))
+	for _, m := range t.Media {
+		item := map[string]interface{}{
+			"id_str":          m.ID,
+			"type":            m.Type,
+			"ext_alt_text":    m.AltText,
+			"media_url_https": s.URL + "/media/" + m.ID + ".jpg",
+		}
+		if m.Type == "video" {
+			item["video_info"] = map[string]interface{}{
+				"variants": []interface{}{
+					map[string]interface{}{
+						"bitrate": 832000,
+						"url":     s.URL + "/media/" + m.ID + ".mp4?tag=10",
+					},
+				},
+			}
+		}
+		media = append(media, item)
+	}
+
+	return map[string]interface{}{
+		"id_str":              t.ID,
+		"conversation_id_str": t.ID,
+		"user_id_str":         t.User.ID,
+		"full_text":           t.Text,
+		"display_text_range":  []int{0, len([]rune(t.Text))},
+		"lang":                lang,
+		"created_at":          createdAt.UTC().Format(scraper.TimeLayout),
+		"extended_entities": map[string]interface{}{
+			"media": media,
+		},
+	}
+}
+
+func graphQLError(message string) map[string]interface{} {
+	return map[string]interface{}{
+		"errors": []interface{}{
+			map[string]interface{}{
+				"message": message,
+			},
+		},
+	}
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func extension(name string) string {
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		return name[i:]
+	}
+	return ""
+}
@@ -0,0 +1,184 @@
+package search
+
+import (
+	"net/url"
+	"strings"
+	"tbm/scraper"
+)
+
+// Target is the tweet a query gets evaluated against
+type Target struct {
+	Tweet      *scraper.TweetResult
+	ScreenName string
+	Name       string
+	// Source of the bookmark the tweet belongs to
+	Source string
+}
+
+//
+// Execute
+// @Description: Evaluate the query against all given documents. Full-text nodes are resolved through the index,
+// filters through the resolved Target of each remaining document.
+// @receiver q *Query
+// @param index *Index
+// @param ids []string all searchable document ids
+// @param resolve func(id string) *Target
+// @return []Result
+func (q *Query) Execute(index *Index, ids []string, resolve func(id string) *Target) []Result {
+	var scores map[string]float64
+
+	// Narrow down the candidates using the positive full-text nodes first
+	for _, node := range q.Nodes {
+		text, ok := node.(*TextNode)
+		if ok == false {
+			continue
+		}
+		matches := index.Lookup(text.Words)
+		if scores == nil {
+			scores = matches
+			continue
+		}
+		for id, score := range scores {
+			if s, ok := matches[id]; ok {
+				scores[id] = score + s
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+	if scores == nil {
+		scores = make(map[string]float64, len(ids))
+		for _, id := range ids {
+			scores[id] = 0
+		}
+	}
+
+	for _, node := range q.Nodes {
+		not, ok := node.(*NotNode)
+		if ok == false {
+			continue
+		}
+		if text, ok := not.Node.(*TextNode); ok {
+			for id := range index.Lookup(text.Words) {
+				delete(scores, id)
+			}
+		}
+	}
+
+	for id := range scores {
+		target := resolve(id)
+		if target == nil || q.Match(target) == false {
+			delete(scores, id)
+		}
+	}
+
+	return Rank(scores)
+}
+
+//
+// Match
+// @Description: Check if all filter nodes of the query match the given target. Full-text nodes are ignored.
+// @receiver q *Query
+// @param target *Target
+// @return bool
+func (q *Query) Match(target *Target) bool {
+	for _, node := range q.Nodes {
+		switch n := node.(type) {
+		case *FilterNode:
+			if n.Match(target) == false {
+				return false
+			}
+		case *NotNode:
+			if filter, ok := n.Node.(*FilterNode); ok && filter.Match(target) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (n *FilterNode) Match(target *Target) bool {
+	tweet := target.Tweet
+
+	switch n.Field {
+	case "from":
+		return strings.ToLower(target.ScreenName) == n.Value
+	case "mentions":
+		for _, mention := range tweet.Entities.UserMentions {
+			if strings.ToLower(mention.ScreenName) == n.Value {
+				return true
+			}
+		}
+	case "hashtag":
+		for _, hashtag := range tweet.Entities.Hashtags {
+			if strings.ToLower(hashtag.Text) == n.Value {
+				return true
+			}
+		}
+	case "domain":
+		for _, u := range tweet.Entities.Urls {
+			if parsed, err := url.Parse(u.ExpandedUrl); err == nil {
+				host := strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+				if host == n.Value || strings.HasSuffix(host, "."+n.Value) {
+					return true
+				}
+			}
+		}
+	case "has":
+		return n.matchHas(tweet)
+	case "is":
+		switch n.Value {
+		case "reply":
+			return tweet.InReplyToStatusIDStr != ""
+		case "quote":
+			return tweet.IsQuoteStatus
+		case "retweet":
+			return tweet.RetweetedStatusIDStr != ""
+		}
+	case "source":
+		if n.Value == "bookmark" {
+			return target.Source == scraper.SourceBookmark
+		}
+		return target.Source == n.Value
+	case "lang":
+		return strings.ToLower(tweet.Lang) == n.Value
+	case "since":
+		createdAt := scraper.ParseTime(tweet.CreatedAt)
+		return createdAt.IsZero() == false && createdAt.Before(n.date) == false
+	case "until":
+		createdAt := scraper.ParseTime(tweet.CreatedAt)
+		return createdAt.IsZero() == false && createdAt.Before(n.date)
+	case "min_likes":
+		return tweet.FavoriteCount >= n.number
+	case "min_retweets":
+		return tweet.RetweetCount >= n.number
+	case "min_replies":
+		return tweet.ReplyCount >= n.number
+	}
+
+	return false
+}
+
+func (n *FilterNode) matchHas(tweet *scraper.TweetResult) bool {
+	switch n.Value {
+	case "media":
+		return len(tweet.ExtendedEntities.Media) > 0 || len(tweet.Entities.Media) > 0
+	case "links":
+		return len(tweet.Entities.Urls) > 0
+	case "hashtags":
+		return len(tweet.Entities.Hashtags) > 0
+	case "mentions":
+		return len(tweet.Entities.UserMentions) > 0
+	}
+
+	kind := n.Value
+	if kind == "gif" {
+		kind = "animated_gif"
+	}
+	for _, media := range tweet.ExtendedEntities.Media {
+		if media.Type == kind {
+			return true
+		}
+	}
+	return false
+}
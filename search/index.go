@@ -0,0 +1,348 @@
+package search
+
+import (
+	"encoding/gob"
+	"errors"
+	"math"
+	"os"
+	"sort"
+	"sync"
+)
+
+const (
+	indexVersion = 2
+
+	// fieldGap separates the positions of two fields, so phrases never match across field boundaries
+	fieldGap = 100
+
+	// BM25 tuning parameters
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+var ErrIndexOutdated = errors.New("search index is outdated")
+
+// Document is a single searchable unit
+type Document struct {
+	ID     string
+	Lang   string
+	Fields []string
+}
+
+// Result is a matching document and its relevance
+type Result struct {
+	ID    string  `json:"id"`
+	Score float64 `json:"score"`
+}
+
+// Index is a persistent inverted index with positional postings
+type Index struct {
+	mx       sync.RWMutex
+	filename string
+	dirty    bool
+	data     *indexData
+	// saveMx serializes writing the index file
+	saveMx sync.Mutex
+}
+
+type indexData struct {
+	Version  int
+	Docs     map[string]*docInfo
+	Postings map[string]map[string][]int
+	Length   int
+}
+
+type docInfo struct {
+	Length int
+	Terms  []string
+}
+
+func NewIndex(filename string) *Index {
+	return &Index{
+		filename: filename,
+		data:     newIndexData(),
+	}
+}
+
+func newIndexData() *indexData {
+	return &indexData{
+		Version:  indexVersion,
+		Docs:     map[string]*docInfo{},
+		Postings: map[string]map[string][]int{},
+	}
+}
+
+//
+// Load
+// @Description: Load a previously saved index. ErrIndexOutdated is returned if the index has to be rebuilt.
+// @receiver i *Index
+// @return error
+func (i *Index) Load() error {
+	f, err := os.Open(i.filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrIndexOutdated
+		}
+		return err
+	}
+	defer f.Close()
+
+	data := newIndexData()
+	if err := gob.NewDecoder(f).Decode(data); err != nil || data.Version != indexVersion {
+		return ErrIndexOutdated
+	}
+
+	i.mx.Lock()
+	defer i.mx.Unlock()
+	i.data = data
+	i.dirty = false
+
+	return nil
+}
+
+//
+// Save
+// @Description: Write the index to disk if it has been changed since the last save. The index is only locked while
+// taking a snapshot, so documents can be added and searched while the snapshot gets encoded.
+// @receiver i *Index
+// @return error
+func (i *Index) Save() error {
+	i.saveMx.Lock()
+	defer i.saveMx.Unlock()
+
+	i.mx.Lock()
+	if i.dirty == false {
+		i.mx.Unlock()
+		return nil
+	}
+	data := i.data.snapshot()
+	i.dirty = false
+	i.mx.Unlock()
+
+	if err := i.write(data); err != nil {
+		// Try again with the next save
+		i.mx.Lock()
+		i.dirty = true
+		i.mx.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (i *Index) write(data *indexData) error {
+	f, err := os.Create(i.filename + ".tmp")
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(i.filename+".tmp", i.filename)
+}
+
+// snapshot copies all maps modified by Add and Remove. Doc infos and positions are never modified once they have
+// been added, so they are shared with the snapshot.
+func (d *indexData) snapshot() *indexData {
+	c := &indexData{
+		Version:  d.Version,
+		Docs:     make(map[string]*docInfo, len(d.Docs)),
+		Postings: make(map[string]map[string][]int, len(d.Postings)),
+		Length:   d.Length,
+	}
+	for id, info := range d.Docs {
+		c.Docs[id] = info
+	}
+	for term, postings := range d.Postings {
+		p := make(map[string][]int, len(postings))
+		for id, positions := range postings {
+			p[id] = positions
+		}
+		c.Postings[term] = p
+	}
+	return c
+}
+
+// Reset removes all documents
+func (i *Index) Reset() {
+	i.mx.Lock()
+	defer i.mx.Unlock()
+
+	i.data = newIndexData()
+	i.dirty = true
+}
+
+// Len returns the number of indexed documents
+func (i *Index) Len() int {
+	i.mx.RLock()
+	defer i.mx.RUnlock()
+
+	return len(i.data.Docs)
+}
+
+// Has checks whether a document has been indexed
+func (i *Index) Has(id string) bool {
+	i.mx.RLock()
+	defer i.mx.RUnlock()
+
+	_, ok := i.data.Docs[id]
+	return ok
+}
+
+//
+// Add
+// @Description: Add or replace a document
+// @receiver i *Index
+// @param doc *Document
+func (i *Index) Add(doc *Document) {
+	i.mx.Lock()
+	defer i.mx.Unlock()
+
+	i.remove(doc.ID)
+
+	info := &docInfo{}
+	seen := map[string]bool{}
+	position := 0
+	for _, field := range doc.Fields {
+		for _, term := range Tokenize(field, doc.Lang) {
+			postings, ok := i.data.Postings[term]
+			if ok == false {
+				postings = map[string][]int{}
+				i.data.Postings[term] = postings
+			}
+			postings[doc.ID] = append(postings[doc.ID], position)
+			if seen[term] == false {
+				seen[term] = true
+				info.Terms = append(info.Terms, term)
+			}
+			position++
+			info.Length++
+		}
+		position += fieldGap
+	}
+
+	i.data.Docs[doc.ID] = info
+	i.data.Length += info.Length
+	i.dirty = true
+}
+
+// Remove deletes a document from the index
+func (i *Index) Remove(id string) {
+	i.mx.Lock()
+	defer i.mx.Unlock()
+
+	i.remove(id)
+}
+
+func (i *Index) remove(id string) {
+	info, ok := i.data.Docs[id]
+	if ok == false {
+		return
+	}
+	for _, term := range info.Terms {
+		delete(i.data.Postings[term], id)
+		if len(i.data.Postings[term]) == 0 {
+			delete(i.data.Postings, term)
+		}
+	}
+	i.data.Length -= info.Length
+	delete(i.data.Docs, id)
+	i.dirty = true
+}
+
+//
+// Lookup
+// @Description: Find all documents containing the given sequence of words and score them using BM25
+// @receiver i *Index
+// @param words []string a single word or a phrase
+// @return map[string]float64 document id => score
+func (i *Index) Lookup(words []string) map[string]float64 {
+	i.mx.RLock()
+	defer i.mx.RUnlock()
+
+	result := map[string]float64{}
+	if len(words) == 0 || len(i.data.Docs) == 0 {
+		return result
+	}
+
+	postings := make([]map[string][]int, len(words))
+	for k, word := range words {
+		postings[k] = map[string][]int{}
+		for _, term := range variants(word) {
+			for id, positions := range i.data.Postings[term] {
+				postings[k][id] = append(postings[k][id], positions...)
+			}
+		}
+	}
+
+	frequencies := map[string]int{}
+	for id, positions := range postings[0] {
+		if len(words) == 1 {
+			frequencies[id] = len(positions)
+			continue
+		}
+
+		following := make([]map[int]bool, len(words))
+		complete := true
+		for k := 1; k < len(words); k++ {
+			p, ok := postings[k][id]
+			if ok == false {
+				complete = false
+				break
+			}
+			following[k] = map[int]bool{}
+			for _, position := range p {
+				following[k][position] = true
+			}
+		}
+		if complete == false {
+			continue
+		}
+
+		count := 0
+		for _, position := range positions {
+			match := true
+			for k := 1; k < len(words); k++ {
+				if following[k][position+k] == false {
+					match = false
+					break
+				}
+			}
+			if match {
+				count++
+			}
+		}
+		if count > 0 {
+			frequencies[id] = count
+		}
+	}
+
+	n := float64(len(i.data.Docs))
+	avg := float64(i.data.Length) / n
+	idf := math.Log(1 + (n-float64(len(frequencies))+0.5)/(float64(len(frequencies))+0.5))
+	for id, frequency := range frequencies {
+		tf := float64(frequency)
+		dl := float64(i.data.Docs[id].Length)
+		result[id] = idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*dl/avg))
+	}
+
+	return result
+}
+
+// Rank sorts a score map by descending relevance
+func Rank(scores map[string]float64) []Result {
+	results := make([]Result, 0, len(scores))
+	for id, score := range scores {
+		results = append(results, Result{ID: id, Score: score})
+	}
+	sort.Slice(results, func(a, b int) bool {
+		if results[a].Score == results[b].Score {
+			return results[a].ID > results[b].ID
+		}
+		return results[a].Score > results[b].Score
+	})
+	return results
+}
@@ -0,0 +1,162 @@
+package search
+
+import (
+	"errors"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text  string
+		lang  string
+		terms []string
+	}{
+		{"Running tests, quickly!", "en", []string{"run", "test", "quick"}},
+		// Languages without a stemmer are only lower cased and folded
+		{"Déjà vu", "", []string{"deja", "vu"}},
+		{"Übergrößen GEHEN", "de", []string{"ubergroßen", "gehen"}},
+		{"#golang @gopher 1.21", "", []string{"golang", "gopher", "1", "21"}},
+	}
+	for _, test := range tests {
+		if terms := Tokenize(test.text, test.lang); reflect.DeepEqual(terms, test.terms) == false {
+			t.Errorf("%q: expected %v, got %v", test.text, test.terms, terms)
+		}
+	}
+}
+
+// newTestIndex creates an index containing a single text field per document
+func newTestIndex(t *testing.T, docs map[string]string) *Index {
+	t.Helper()
+
+	index := NewIndex(path.Join(t.TempDir(), "search.idx"))
+	for id, text := range docs {
+		index.Add(&Document{ID: id, Lang: "en", Fields: []string{text}})
+	}
+	return index
+}
+
+func TestIndexLookup(t *testing.T) {
+	index := newTestIndex(t, map[string]string{
+		"1": "the quick brown fox jumps",
+		"2": "brown bears are quick",
+		"3": "a fox, a fox and another fox",
+	})
+
+	tests := []struct {
+		words []string
+		ids   []string
+	}{
+		{[]string{"fox"}, []string{"3", "1"}},
+		{[]string{"quick"}, []string{"2", "1"}},
+		// Stemmed variants of the query word match as well
+		{[]string{"jumping"}, []string{"1"}},
+		{[]string{"quick", "brown"}, []string{"1"}},
+		{[]string{"brown", "quick"}, []string{}},
+		{[]string{"unknown"}, []string{}},
+	}
+	for _, test := range tests {
+		results := Rank(index.Lookup(test.words))
+		ids := make([]string, len(results))
+		for i, result := range results {
+			ids[i] = result.ID
+		}
+		if reflect.DeepEqual(ids, test.ids) == false {
+			t.Errorf("%v: expected %v, got %v", test.words, test.ids, ids)
+		}
+	}
+}
+
+func TestIndexPhraseDoesNotCrossFields(t *testing.T) {
+	index := NewIndex(path.Join(t.TempDir(), "search.idx"))
+	index.Add(&Document{ID: "1", Lang: "en", Fields: []string{"written in go", "lang tour"}})
+
+	if results := index.Lookup([]string{"go", "lang"}); len(results) != 0 {
+		t.Errorf("expected the phrase not to match across fields, got %v", results)
+	}
+	if results := index.Lookup([]string{"lang", "tour"}); len(results) != 1 {
+		t.Errorf("expected the phrase to match inside the second field, got %v", results)
+	}
+}
+
+func TestIndexAddReplacesDocument(t *testing.T) {
+	index := newTestIndex(t, map[string]string{"1": "old text"})
+	index.Add(&Document{ID: "1", Lang: "en", Fields: []string{"new text"}})
+
+	if results := index.Lookup([]string{"old"}); len(results) != 0 {
+		t.Errorf("expected the old text to be removed, got %v", results)
+	}
+	if results := index.Lookup([]string{"new"}); len(results) != 1 {
+		t.Errorf("expected the new text to be indexed, got %v", results)
+	}
+
+	index.Remove("1")
+	if index.Len() != 0 || index.Has("1") {
+		t.Errorf("expected an empty index, got %d documents", index.Len())
+	}
+	if len(index.data.Postings) != 0 || index.data.Length != 0 {
+		t.Errorf("expected all postings to be removed, got %v", index.data.Postings)
+	}
+}
+
+func TestIndexSaveLoad(t *testing.T) {
+	index := newTestIndex(t, map[string]string{
+		"1": "the quick brown fox",
+		"2": "lazy dogs",
+	})
+	if err := index.Save(); err != nil {
+		t.Fatalf("failed to save the index: %s", err.Error())
+	}
+	// Documents added after a save are written with the next one
+	index.Add(&Document{ID: "3", Lang: "en", Fields: []string{"quick dogs"}})
+
+	loaded := NewIndex(index.filename)
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("failed to load the index: %s", err.Error())
+	}
+	if loaded.Len() != 2 || loaded.Has("3") {
+		t.Fatalf("expected the 2 saved documents, got %d", loaded.Len())
+	}
+	if results := loaded.Lookup([]string{"brown", "fox"}); len(results) != 1 {
+		t.Errorf("expected the phrase positions to be restored, got %v", results)
+	}
+
+	if err := index.Save(); err != nil {
+		t.Fatalf("failed to save the index: %s", err.Error())
+	}
+	if err := loaded.Load(); err != nil || loaded.Len() != 3 {
+		t.Errorf("expected 3 documents after the second save, got %d (%v)", loaded.Len(), err)
+	}
+	if reflect.DeepEqual(loaded.Lookup([]string{"quick"}), index.Lookup([]string{"quick"})) == false {
+		t.Error("expected the loaded index to score like the saved one")
+	}
+}
+
+func TestIndexLoadOutdated(t *testing.T) {
+	dir := t.TempDir()
+
+	missing := NewIndex(path.Join(dir, "missing.idx"))
+	if err := missing.Load(); errors.Is(err, ErrIndexOutdated) == false {
+		t.Errorf("expected a missing index to be outdated, got %v", err)
+	}
+
+	corrupt := path.Join(dir, "corrupt.idx")
+	if err := os.WriteFile(corrupt, []byte("no gob"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewIndex(corrupt).Load(); errors.Is(err, ErrIndexOutdated) == false {
+		t.Errorf("expected a corrupt index to be outdated, got %v", err)
+	}
+
+	old := NewIndex(path.Join(dir, "old.idx"))
+	data := newIndexData()
+	data.Version = indexVersion - 1
+	if err := old.write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := old.Load(); errors.Is(err, ErrIndexOutdated) == false {
+		t.Errorf("expected an index of version %d to be outdated, got %v", data.Version, err)
+	}
+}
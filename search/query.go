@@ -0,0 +1,228 @@
+package search
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	DateLayout = "2006-01-02"
+)
+
+// Node is a single element of a parsed query
+type Node interface {
+	String() string
+}
+
+// TextNode matches a word or a "quoted phrase" against the full-text index
+type TextNode struct {
+	Words  []string
+	Phrase bool
+}
+
+// FilterNode matches an operator such as from:name against the tweet itself
+type FilterNode struct {
+	Field string
+	Value string
+
+	date   time.Time
+	number int
+}
+
+// NotNode negates the wrapped node
+type NotNode struct {
+	Node Node
+}
+
+// Query is a list of nodes which all have to match
+type Query struct {
+	Nodes []Node
+}
+
+// ParseError describes an invalid query
+type ParseError struct {
+	Position int
+	Message  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("invalid query at position %d: %s", e.Position+1, e.Message)
+}
+
+func (n *TextNode) String() string {
+	if n.Phrase {
+		return `"` + strings.Join(n.Words, " ") + `"`
+	}
+	return strings.Join(n.Words, " ")
+}
+
+func (n *FilterNode) String() string {
+	if n.Field == "hashtag" {
+		return "#" + n.Value
+	}
+	return n.Field + ":" + n.Value
+}
+
+func (n *NotNode) String() string {
+	return "-" + n.Node.String()
+}
+
+func (q *Query) String() string {
+	parts := make([]string, len(q.Nodes))
+	for i, node := range q.Nodes {
+		parts[i] = node.String()
+	}
+	return strings.Join(parts, " ")
+}
+
+// filterValues lists the allowed values of operators with a fixed set of values
+var filterValues = map[string][]string{
+	"has":    {"media", "photo", "video", "gif", "links", "hashtags", "mentions"},
+	"is":     {"reply", "quote", "retweet"},
+	"source": {"bookmark", "archive"},
+}
+
+// filterOperators lists all supported operators and the kind of value they expect
+var filterOperators = map[string]string{
+	"from":         "name",
+	"mentions":     "name",
+	"domain":       "text",
+	"has":          "enum",
+	"is":           "enum",
+	"source":       "enum",
+	"lang":         "text",
+	"since":        "date",
+	"until":        "date",
+	"min_likes":    "number",
+	"min_retweets": "number",
+	"min_replies":  "number",
+}
+
+//
+// ParseQuery
+// @Description: Parse a search query into its nodes
+// @param query string
+// @return *Query
+// @return error *ParseError describing the first problem found
+func ParseQuery(query string) (*Query, error) {
+	q := &Query{
+		Nodes: make([]Node, 0),
+	}
+	runes := []rune(query)
+	pos := 0
+
+	for pos < len(runes) {
+		if unicode.IsSpace(runes[pos]) {
+			pos++
+			continue
+		}
+
+		negate := false
+		if runes[pos] == '-' && pos+1 < len(runes) && unicode.IsSpace(runes[pos+1]) == false {
+			negate = true
+			pos++
+		}
+
+		var node Node
+		if runes[pos] == '"' {
+			end := pos + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, &ParseError{Position: pos, Message: "missing closing quote"}
+			}
+			words := Words(string(runes[pos+1 : end]))
+			pos = end + 1
+			if len(words) == 0 {
+				continue
+			}
+			node = &TextNode{Words: words, Phrase: len(words) > 1}
+		} else {
+			end := pos
+			for end < len(runes) && unicode.IsSpace(runes[end]) == false {
+				end++
+			}
+			token := string(runes[pos:end])
+			var err *ParseError
+			node, err = parseToken(token, pos)
+			if err != nil {
+				return nil, err
+			}
+			pos = end
+			if node == nil {
+				continue
+			}
+		}
+
+		if negate {
+			node = &NotNode{Node: node}
+		}
+		q.Nodes = append(q.Nodes, node)
+	}
+
+	return q, nil
+}
+
+func parseToken(token string, pos int) (Node, *ParseError) {
+	if strings.HasPrefix(token, "#") {
+		tag := strings.TrimPrefix(token, "#")
+		if tag == "" {
+			return nil, &ParseError{Position: pos, Message: "missing hashtag after #"}
+		}
+		return &FilterNode{Field: "hashtag", Value: strings.ToLower(tag)}, nil
+	}
+
+	if parts := strings.SplitN(token, ":", 2); len(parts) == 2 {
+		field := strings.ToLower(parts[0])
+		if kind, ok := filterOperators[field]; ok {
+			return parseFilter(field, kind, parts[1], pos)
+		}
+	}
+
+	words := Words(token)
+	if len(words) == 0 {
+		return nil, nil
+	}
+	return &TextNode{Words: words, Phrase: len(words) > 1}, nil
+}
+
+func parseFilter(field, kind, value string, pos int) (Node, *ParseError) {
+	value = strings.Trim(value, `"`)
+	if value == "" {
+		return nil, &ParseError{Position: pos, Message: fmt.Sprintf("missing value for %s:", field)}
+	}
+
+	node := &FilterNode{Field: field, Value: strings.ToLower(value)}
+	switch kind {
+	case "name":
+		node.Value = strings.TrimPrefix(node.Value, "@")
+	case "enum":
+		for _, allowed := range filterValues[field] {
+			if allowed == node.Value {
+				return node, nil
+			}
+		}
+		return nil, &ParseError{
+			Position: pos,
+			Message:  fmt.Sprintf("unknown value \"%s\" for %s: (expected one of %s)", value, field, strings.Join(filterValues[field], ", ")),
+		}
+	case "date":
+		date, err := time.Parse(DateLayout, value)
+		if err != nil {
+			return nil, &ParseError{Position: pos, Message: fmt.Sprintf("invalid date \"%s\" for %s: (expected YYYY-MM-DD)", value, field)}
+		}
+		node.date = date
+	case "number":
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 0 {
+			return nil, &ParseError{Position: pos, Message: fmt.Sprintf("invalid number \"%s\" for %s:", value, field)}
+		}
+		node.number = number
+	}
+
+	return node, nil
+}
@@ -0,0 +1,158 @@
+package search
+
+import (
+	"encoding/json"
+	"errors"
+	"path"
+	"reflect"
+	"strings"
+	"tbm/scraper"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		nodes string
+	}{
+		{"", ""},
+		{"  golang  ", "golang"},
+		{"Go Lang", "go lang"},
+		{`"go lang" tour`, `"go lang" tour`},
+		{`"golang"`, "golang"},
+		{`""`, ""},
+		{"-golang", "-golang"},
+		{`-"go lang"`, `-"go lang"`},
+		{"- golang", "golang"},
+		{"#GoLang", "#golang"},
+		{"-#golang", "-#golang"},
+		{"from:@Golang", "from:golang"},
+		{"FROM:golang", "from:golang"},
+		{`from:"golang"`, "from:golang"},
+		{"-from:golang mentions:gopher", "-from:golang mentions:gopher"},
+		{"has:video is:reply source:archive", "has:video is:reply source:archive"},
+		{"since:2022-01-01 until:2022-12-31", "since:2022-01-01 until:2022-12-31"},
+		{"min_likes:10 min_retweets:0 min_replies:3", "min_likes:10 min_retweets:0 min_replies:3"},
+		{"domain:go.dev lang:EN", "domain:go.dev lang:en"},
+		// Unknown operators are searched as text, words joined by punctuation as phrase
+		{"foo:bar", `"foo bar"`},
+		{"go-lang", `"go lang"`},
+		{"@gopher", "gopher"},
+	}
+	for _, test := range tests {
+		q, err := ParseQuery(test.query)
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", test.query, err.Error())
+			continue
+		}
+		if nodes := q.String(); nodes != test.nodes {
+			t.Errorf("%q: expected %q, got %q", test.query, test.nodes, nodes)
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []struct {
+		query    string
+		position int
+		message  string
+	}{
+		{`golang "go lang`, 7, "missing closing quote"},
+		{"#", 0, "missing hashtag after #"},
+		{"golang from:", 7, "missing value for from:"},
+		{`from:""`, 0, "missing value for from:"},
+		{"has:audio", 0, `unknown value "audio" for has: (expected one of media, photo, video, gif, links, hashtags, mentions)`},
+		{"is:bookmark", 0, `unknown value "bookmark" for is:`},
+		{"source:har", 0, `unknown value "har" for source:`},
+		{"since:yesterday", 0, `invalid date "yesterday" for since: (expected YYYY-MM-DD)`},
+		{"until:2022-13-01", 0, `invalid date "2022-13-01" for until:`},
+		{"min_likes:many", 0, `invalid number "many" for min_likes:`},
+		{"min_likes:-1", 0, `invalid number "-1" for min_likes:`},
+		// Positions count runes, not bytes
+		{"größe #", 6, "missing hashtag after #"},
+		{"-from:", 1, "missing value for from:"},
+	}
+	for _, test := range tests {
+		_, err := ParseQuery(test.query)
+		var parseErr *ParseError
+		if errors.As(err, &parseErr) == false {
+			t.Errorf("%q: expected a parse error, got %v", test.query, err)
+			continue
+		}
+		if parseErr.Position != test.position || strings.HasPrefix(parseErr.Message, test.message) == false {
+			t.Errorf("%q: expected %q at %d, got %q at %d", test.query, test.message, test.position, parseErr.Message, parseErr.Position)
+		}
+	}
+}
+
+func TestQueryExecute(t *testing.T) {
+	tweets := map[string]*Target{
+		"1": {ScreenName: "golang", Source: scraper.SourceBookmark},
+		"2": {ScreenName: "gopher", Source: scraper.SourceBookmark},
+		"3": {ScreenName: "gopher", Source: scraper.SourceArchive},
+		"4": {ScreenName: "rustlang", Source: scraper.SourceBookmark},
+	}
+	texts := map[string]string{
+		"1": "Go 1.18 brings generics to go",
+		"2": "Generics in Rust and go",
+		"3": "Learning go the hard way",
+		"4": "Rust generics",
+	}
+	for id, target := range tweets {
+		target.Tweet = &scraper.TweetResult{}
+		target.Tweet.FullText = texts[id]
+		target.Tweet.Lang = "en"
+		target.Tweet.CreatedAt = "Sat Jan 01 12:00:00 +0000 2022"
+		target.Tweet.FavoriteCount = len(texts[id])
+	}
+	tweets["4"].Tweet.CreatedAt = "Mon Jan 02 12:00:00 +0000 2023"
+	if err := json.Unmarshal([]byte(`{"hashtags": [{"text": "Generics"}]}`), &tweets["2"].Tweet.Entities); err != nil {
+		t.Fatal(err)
+	}
+
+	index := NewIndex(path.Join(t.TempDir(), "search.idx"))
+	ids := make([]string, 0, len(texts))
+	for id, text := range texts {
+		index.Add(&Document{ID: id, Lang: "en", Fields: []string{text}})
+		ids = append(ids, id)
+	}
+
+	tests := []struct {
+		query string
+		ids   []string
+	}{
+		// Ranked by relevance, go appears twice inside the first tweet
+		{"go", []string{"1", "3", "2"}},
+		{"generics go", []string{"1", "2"}},
+		{`"rust and go"`, []string{"2"}},
+		{"generics -rust", []string{"1"}},
+		{`go -"hard way"`, []string{"1", "2"}},
+		{"from:gopher", []string{"3", "2"}},
+		{"go -from:gopher", []string{"1"}},
+		{"source:archive", []string{"3"}},
+		// Shorter tweets rank higher
+		{"-source:archive generics", []string{"4", "2", "1"}},
+		{"#generics", []string{"2"}},
+		{"-#generics generics", []string{"4", "1"}},
+		{"since:2023-01-01", []string{"4"}},
+		{"until:2023-01-01 rust", []string{"2"}},
+		{"min_likes:25", []string{"1"}},
+		{"python", []string{}},
+	}
+	for _, test := range tests {
+		q, err := ParseQuery(test.query)
+		if err != nil {
+			t.Fatalf("%q: %s", test.query, err.Error())
+		}
+		results := q.Execute(index, ids, func(id string) *Target {
+			return tweets[id]
+		})
+		found := make([]string, len(results))
+		for i, result := range results {
+			found[i] = result.ID
+		}
+		if reflect.DeepEqual(found, test.ids) == false {
+			t.Errorf("%q: expected %v, got %v", test.query, test.ids, found)
+		}
+	}
+}
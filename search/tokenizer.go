@@ -0,0 +1,94 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/kljensen/snowball"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// stemmerLanguages maps twitter language codes onto the supported snowball stemmers
+var stemmerLanguages = map[string]string{
+	"en": "english",
+	"es": "spanish",
+	"fr": "french",
+	"ru": "russian",
+	"sv": "swedish",
+	"no": "norwegian",
+	"nb": "norwegian",
+	"hu": "hungarian",
+}
+
+//
+// Tokenize
+// @Description: Split a given text into normalized and stemmed terms
+// @param text string
+// @param lang string twitter language code used to pick the stemmer
+// @return []string
+func Tokenize(text, lang string) []string {
+	words := Words(text)
+	terms := make([]string, len(words))
+	for i, word := range words {
+		terms[i] = Fold(Stem(word, lang))
+	}
+	return terms
+}
+
+//
+// Words
+// @Description: Split a given text into lower cased words
+// @param text string
+// @return []string
+func Words(text string) []string {
+	text = strings.ToLower(norm.NFC.String(text))
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return unicode.IsLetter(r) == false && unicode.IsNumber(r) == false && unicode.Is(unicode.Mn, r) == false
+	})
+}
+
+//
+// Stem
+// @Description: Reduce a word to its stem if a stemmer for the given language exists
+// @param word string
+// @param lang string
+// @return string
+func Stem(word, lang string) string {
+	language, ok := stemmerLanguages[lang]
+	if ok == false {
+		return word
+	}
+	if stemmed, err := snowball.Stem(word, language, true); err == nil && stemmed != "" {
+		return stemmed
+	}
+	return word
+}
+
+//
+// Fold
+// @Description: Remove all diacritics and compatibility characters from a given term
+// @param term string
+// @return string
+func Fold(term string) string {
+	t := transform.Chain(norm.NFKD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	if folded, _, err := transform.String(t, term); err == nil {
+		return folded
+	}
+	return term
+}
+
+// variants returns every form a query word might have been indexed as
+func variants(word string) []string {
+	result := []string{Fold(word)}
+	seen := map[string]bool{result[0]: true}
+	for lang := range stemmerLanguages {
+		v := Fold(Stem(word, lang))
+		if seen[v] == false {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+	"tbm/utils/log"
+)
+
+const (
+	ApiPrefix = "/api/v1/"
+)
+
+// ApiHandler executes a command and returns the http status code and the encoded response
+type ApiHandler func(command string, payload map[string]interface{}) (int, []byte)
+
+// Export is streamed to the client by the export endpoint
+type Export interface {
+	ContentType() string
+	FileName() string
+	Write(w io.Writer) (int, error)
+}
+
+// ExportHandler prepares an export. If that fails, the http status code and the encoded response are returned instead.
+type ExportHandler func(payload map[string]interface{}) (Export, int, []byte)
+
+func (s *Server) setApiRoutes() {
+	s.mux.HandleFunc(ApiPrefix+"tweets", s.protect(s.apiEndpoint("get_tweets")))
+	s.mux.HandleFunc(ApiPrefix+"tweets/", s.protect(s.apiTweetEndpoint))
+	s.mux.HandleFunc(ApiPrefix+"search", s.protect(s.apiEndpoint("search_tweets")))
+	s.mux.HandleFunc(ApiPrefix+"export", s.protect(s.apiExportEndpoint))
+	s.mux.HandleFunc(ApiPrefix+"users/", s.protect(s.apiUserEndpoint))
+	s.mux.HandleFunc(ApiPrefix+"stats", s.protect(s.apiEndpoint("get_stats")))
+	s.mux.HandleFunc(ApiPrefix+"rate_limit", s.protect(s.apiEndpoint("get_rate_limit")))
+	s.mux.HandleFunc(ApiPrefix+"openapi.json", s.openApiEndpoint)
+}
+
+//
+// apiEndpoint
+// @Description: Create a handler forwarding the request as the given command
+// @receiver s *Server
+// @param command string
+// @return http.HandlerFunc
+func (s *Server) apiEndpoint(command string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.serveApi(w, r, command, apiPayload(r))
+	}
+}
+
+// apiUserEndpoint serves /api/v1/users/{id}
+func (s *Server) apiUserEndpoint(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, ApiPrefix+"users/")
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, "404 page not found", http.StatusNotFound)
+		return
+	}
+	payload := apiPayload(r)
+	payload["id"] = id
+	s.serveApi(w, r, "get_user", payload)
+}
+
+// apiTweetEndpoint serves /api/v1/tweets/{id} and /api/v1/tweets/{id}/thread
+func (s *Server) apiTweetEndpoint(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, ApiPrefix+"tweets/"), "/")
+	payload := apiPayload(r)
+	payload["id"] = parts[0]
+
+	switch {
+	case len(parts) == 1 && parts[0] != "":
+		s.serveApi(w, r, "get_tweet", payload)
+	case len(parts) == 2 && parts[0] != "" && parts[1] == "thread":
+		s.serveApi(w, r, "get_thread", payload)
+	default:
+		http.Error(w, "404 page not found", http.StatusNotFound)
+	}
+}
+
+// apiExportEndpoint streams the tweets matching a search as csv or json lines
+func (s *Server) apiExportEndpoint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	e, status, body := s.OnExport(apiPayload(r))
+	if e == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write(body)
+		return
+	}
+
+	w.Header().Set("Content-Type", e.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", e.FileName()))
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := e.Write(w); err != nil {
+		log.Error("Failed to stream the export: %s", err.Error())
+	}
+}
+
+func (s *Server) serveApi(w http.ResponseWriter, r *http.Request, command string, payload map[string]interface{}) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status, body := s.OnApiRequest(command, payload)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+func (s *Server) openApiEndpoint(w http.ResponseWriter, r *http.Request) {
+	b, err := fs.ReadFile(s.assets, "static/api/openapi.json")
+	if err != nil {
+		log.Error("Failed to load the api specification: %s", err.Error())
+		http.Error(w, "500 api specification not found", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(b)
+}
+
+// apiPayload converts all query parameters into a command payload
+func apiPayload(r *http.Request) map[string]interface{} {
+	payload := map[string]interface{}{}
+	for key, values := range r.URL.Query() {
+		if len(values) > 0 {
+			payload[key] = values[len(values)-1]
+		}
+	}
+	if q, ok := payload["q"]; ok {
+		payload["query"] = q
+		delete(payload, "q")
+	}
+	return payload
+}
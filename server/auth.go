@@ -0,0 +1,362 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"golang.org/x/crypto/bcrypt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"tbm/utils/log"
+	"time"
+)
+
+const (
+	SessionCookieName     = "tbm_session"
+	DefaultSessionTimeout = 7 * 24 * time.Hour
+)
+
+// AuthOptions configure how clients have to authenticate themselves. Authentication is disabled as long as
+// neither a token nor a username and password hash are configured.
+type AuthOptions struct {
+	Token          string   `json:"token"`
+	Username       string   `json:"username"`
+	PasswordHash   string   `json:"password_hash"`
+	AllowedOrigins []string `json:"allowed_origins"`
+
+	SessionTimeout    time.Duration `json:"-"`
+	RawSessionTimeout string        `json:"session_timeout"`
+
+	sessions map[string]time.Time
+	mx       sync.Mutex
+}
+
+//
+// Enabled
+// @Description: Check if any kind of authentication has been configured
+// @receiver a *AuthOptions
+// @return bool
+func (a *AuthOptions) Enabled() bool {
+	return a.Token != "" || a.passwordEnabled()
+}
+
+func (a *AuthOptions) passwordEnabled() bool {
+	return a.Username != "" && a.PasswordHash != ""
+}
+
+//
+// HashPassword
+// @Description: Create a bcrypt hash which can be used as password_hash
+// @param password string
+// @return string
+// @return error
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (a *AuthOptions) checkToken(token string) bool {
+	return a.Token != "" && subtle.ConstantTimeCompare([]byte(a.Token), []byte(token)) == 1
+}
+
+func (a *AuthOptions) checkPassword(username, password string) bool {
+	if a.passwordEnabled() == false {
+		return false
+	}
+	validUser := subtle.ConstantTimeCompare([]byte(a.Username), []byte(username)) == 1
+	validPassword := bcrypt.CompareHashAndPassword([]byte(a.PasswordHash), []byte(password)) == nil
+	return validUser && validPassword
+}
+
+// newSession creates a new random session id and drops all expired sessions
+func (a *AuthOptions) newSession() (string, time.Time, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+	id := hex.EncodeToString(b)
+
+	timeout := a.SessionTimeout
+	if timeout <= 0 {
+		timeout = DefaultSessionTimeout
+	}
+	expires := time.Now().Add(timeout)
+
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	if a.sessions == nil {
+		a.sessions = map[string]time.Time{}
+	}
+	for session, expiry := range a.sessions {
+		if time.Now().After(expiry) {
+			delete(a.sessions, session)
+		}
+	}
+	a.sessions[id] = expires
+
+	return id, expires, nil
+}
+
+func (a *AuthOptions) checkSession(id string) bool {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	expiry, ok := a.sessions[id]
+	if ok && time.Now().After(expiry) {
+		delete(a.sessions, id)
+		return false
+	}
+	return ok
+}
+
+func (a *AuthOptions) removeSession(id string) {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	delete(a.sessions, id)
+}
+
+//
+// authenticated
+// @Description: Check if the request carries a valid bearer token or session cookie
+// @receiver s *Server
+// @param r *http.Request
+// @return bool
+func (s *Server) authenticated(r *http.Request) bool {
+	if s.Auth.Enabled() == false {
+		return true
+	}
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		if s.Auth.checkToken(strings.TrimPrefix(header, "Bearer ")) {
+			return true
+		}
+	}
+	if cookie, err := r.Cookie(SessionCookieName); err == nil {
+		return s.Auth.checkSession(cookie.Value)
+	}
+	return false
+}
+
+//
+// checkOrigin
+// @Description: Only accept browser requests issued by the web interface itself or an allowed origin.
+// Requests without an Origin header (e.g. scripts) are accepted as long as they are sent to a trusted host.
+// @receiver s *Server
+// @param r *http.Request
+// @return bool
+func (s *Server) checkOrigin(r *http.Request) bool {
+	if s.trustedHost(r.Host) == false {
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range s.Auth.AllowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadHostNames collects the names the server can be addressed by besides its ip addresses: the configured host,
+// the name of the machine (e.g. inside a LAN) and the names the TLS certificate is valid for
+func (s *Server) loadHostNames() {
+	names := []string{"localhost"}
+	if net.ParseIP(s.Host) == nil && s.Host != "" && s.Host != "localhost" {
+		names = append(names, s.Host)
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		names = append(names, hostname, hostname+".local")
+	}
+	if s.TLS.Enabled() {
+		certNames, err := s.TLS.HostNames()
+		if err != nil {
+			log.Warning("Failed to read the host names of the certificate: %s", err.Error())
+		}
+		for _, name := range certNames {
+			if strings.HasPrefix(name, "*.") == false && containsFold(names, name) == false {
+				names = append(names, name)
+			}
+		}
+	}
+
+	s.mx.Lock()
+	s.hostNames = names
+	s.mx.Unlock()
+	log.Info("Accepting requests addressed to %s, any ip address or the hosts of server.auth.allowed_origins", strings.Join(names, ", "))
+}
+
+// trustedHost prevents DNS rebinding: a foreign domain resolving to the server would otherwise count as the server
+// itself. Accepted are ip addresses, the names collected by loadHostNames and the hosts of all allowed origins.
+func (s *Server) trustedHost(host string) bool {
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	hostname = strings.TrimSuffix(strings.Trim(hostname, "[]"), ".")
+	switch {
+	case hostname == "":
+		return false
+	case strings.EqualFold(hostname, "localhost"), strings.EqualFold(hostname, s.Host), net.ParseIP(hostname) != nil:
+		return true
+	}
+
+	s.mx.RLock()
+	known := containsFold(s.hostNames, hostname)
+	s.mx.RUnlock()
+	if known {
+		return true
+	}
+	for _, allowed := range s.Auth.AllowedOrigins {
+		if u, err := url.Parse(allowed); err == nil && strings.EqualFold(u.Hostname(), hostname) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// protect
+// @Description: Wrap a handler which may only be accessed by authenticated clients
+// @receiver s *Server
+// @param handler http.HandlerFunc
+// @return http.HandlerFunc
+func (s *Server) protect(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.checkOrigin(r) == false {
+			log.Warning("Rejected request from origin \"%s\" to host \"%s\"", r.Header.Get("Origin"), r.Host)
+			http.Error(w, "403 origin not allowed", http.StatusForbidden)
+			return
+		}
+		if s.authenticated(r) == false {
+			if r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/thread/") {
+				http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tbm"`)
+			http.Error(w, "401 unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (s *Server) loginEndpoint(w http.ResponseWriter, r *http.Request) {
+	next := r.FormValue("next")
+	if strings.HasPrefix(next, "/") == false || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		next = "/"
+	}
+
+	if s.Auth.Enabled() == false {
+		http.Redirect(w, r, next, http.StatusSeeOther)
+		return
+	}
+
+	status := http.StatusOK
+	message := ""
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost:
+		if s.checkOrigin(r) == false {
+			http.Error(w, "403 origin not allowed", http.StatusForbidden)
+			return
+		}
+		valid := s.Auth.checkPassword(r.PostFormValue("username"), r.PostFormValue("password"))
+		if valid == false && r.PostFormValue("token") != "" {
+			valid = s.Auth.checkToken(r.PostFormValue("token"))
+		}
+		if valid {
+			id, expires, err := s.Auth.newSession()
+			if err != nil {
+				log.Error("Failed to create a session: %s", err.Error())
+				http.Error(w, "500 failed to create a session", http.StatusInternalServerError)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{
+				Name:     SessionCookieName,
+				Value:    id,
+				Path:     "/",
+				Expires:  expires,
+				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteStrictMode,
+			})
+			http.Redirect(w, r, next, http.StatusSeeOther)
+			return
+		}
+		log.Warning("Failed login attempt from %s", r.RemoteAddr)
+		status = http.StatusUnauthorized
+		message = "Invalid credentials"
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tmpl := s.template.Lookup("login")
+	if tmpl == nil {
+		log.Error("Template not found")
+		http.Error(w, "500 template not found", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := tmpl.Execute(w, map[string]interface{}{
+		"Title":    "Login",
+		"Error":    message,
+		"Next":     next,
+		"Password": s.Auth.passwordEnabled(),
+		"Token":    s.Auth.Token != "",
+	}); err != nil {
+		log.Error("Failed to serve the login page: %s", err.Error())
+	}
+}
+
+func (s *Server) logoutEndpoint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.checkOrigin(r) == false {
+		http.Error(w, "403 origin not allowed", http.StatusForbidden)
+		return
+	}
+	if cookie, err := r.Cookie(SessionCookieName); err == nil {
+		s.Auth.removeSession(cookie.Value)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	s := &Server{
+		Host: "bookmarks.lan",
+		Auth: AuthOptions{
+			AllowedOrigins: []string{"https://tbm.example.com"},
+		},
+	}
+	tests := []struct {
+		host   string
+		origin string
+		valid  bool
+	}{
+		{"localhost:4788", "", true},
+		{"localhost:4788", "http://localhost:4788", true},
+		{"127.0.0.1:4788", "http://127.0.0.1:4788", true},
+		{"[::1]:4788", "http://[::1]:4788", true},
+		{"bookmarks.lan:4788", "http://bookmarks.lan:4788", true},
+		{"tbm.example.com", "https://tbm.example.com", true},
+		{"localhost:4788", "https://tbm.example.com", true},
+		{"localhost:4788", "http://evil.example", false},
+		// DNS rebinding: a foreign domain resolving to the server
+		{"evil.example:4788", "http://evil.example:4788", false},
+		{"evil.example:4788", "", false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/ws", nil)
+		r.Host = test.host
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		if valid := s.checkOrigin(r); valid != test.valid {
+			t.Errorf("host %s with origin %q: expected %v, got %v", test.host, test.origin, test.valid, valid)
+		}
+	}
+}
+
+func TestCheckOriginLanHost(t *testing.T) {
+	s := &Server{Host: "0.0.0.0"}
+	s.TLS.SelfSigned = true
+	if err := s.TLS.Prepare(t.TempDir(), "bookmarks.lan"); err != nil {
+		t.Fatalf("failed to create the certificate: %s", err.Error())
+	}
+	s.loadHostNames()
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		host   string
+		origin string
+		valid  bool
+	}{
+		{"192.168.1.20:4788", "https://192.168.1.20:4788", true},
+		{hostname + ":4788", "https://" + hostname + ":4788", true},
+		{hostname + ".local:4788", "https://" + hostname + ".local:4788", true},
+		// Host names of the certificate
+		{"bookmarks.lan:4788", "https://bookmarks.lan:4788", true},
+		{"evil.example:4788", "https://evil.example:4788", false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/ws", nil)
+		r.Host = test.host
+		r.Header.Set("Origin", test.origin)
+		if valid := s.checkOrigin(r); valid != test.valid {
+			t.Errorf("host %s with origin %q: expected %v, got %v", test.host, test.origin, test.valid, valid)
+		}
+	}
+}
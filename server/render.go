@@ -0,0 +1,124 @@
+package server
+
+import (
+	"github.com/microcosm-cc/bluemonday"
+	"html/template"
+	"io/fs"
+	"regexp"
+	"strings"
+	"tbm/scraper"
+)
+
+// TemplateUrls builds all links used inside the html templates. The server uses absolute paths while a static
+// export needs relative ones.
+type TemplateUrls struct {
+	// Asset returns the url of a file inside static/public, e.g. "css/style.css"
+	Asset func(name string) string
+	// Home returns the url of the start page
+	Home func() string
+	// Media returns the url of a photo or profile image
+	Media func(id string) string
+	// Video returns the url of a video
+	Video func(id string) string
+}
+
+// serverUrls are the urls served by the Server itself
+var serverUrls = TemplateUrls{
+	Asset: func(name string) string {
+		return "/" + name
+	},
+	Home: func() string {
+		return "/"
+	},
+	Media: func(id string) string {
+		return "/media/" + id
+	},
+	Video: func(id string) string {
+		return "/video/" + id
+	},
+}
+
+type ThreadItem struct {
+	Tweet scraper.TweetResult
+	User  scraper.ConversationUser
+}
+
+//
+// ParseTemplates
+// @Description: Parse all html templates
+// @param assets fs.FS containing the static folder
+// @param state func() map[string]interface{} returns the state available via GetState
+// @param urls TemplateUrls
+// @return *template.Template
+// @return error
+func ParseTemplates(assets fs.FS, state func() map[string]interface{}, urls TemplateUrls) (*template.Template, error) {
+	templates, err := fs.Sub(assets, "static/template")
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl := template.New("")
+	tmpl.Funcs(template.FuncMap{
+		"html": func(str string) template.HTML {
+			return template.HTML(str)
+		},
+		"GetState": state,
+		"Asset":    urls.Asset,
+		"Home":     urls.Home,
+		"Media":    urls.Media,
+		"Video":    urls.Video,
+	})
+	return tmpl.ParseFS(templates, "*.tmpl")
+}
+
+//
+// ThreadData
+// @Description: Build the data of the thread template. The text of every tweet contains links to its hashtags,
+// mentions and urls.
+// @param cache *scraper.CachedTweet
+// @param state map[string]interface{}
+// @return map[string]interface{}
+func ThreadData(cache *scraper.CachedTweet, state map[string]interface{}) map[string]interface{} {
+	title := bluemonday.StripTagsPolicy().Sanitize(cache.Tweet.FullText)
+	if len(title) > 16 {
+		title = title[0:13] + "..."
+	}
+
+	thread := map[string]*ThreadItem{}
+
+	for tweetId, tweet := range cache.Conversation.GlobalObjects.Tweets {
+		user, ok := cache.Conversation.GlobalObjects.Users[tweet.UserIdStr]
+		if !ok {
+			user = scraper.ConversationUser{}
+		}
+
+		for _, hashtag := range tweet.Entities.Hashtags {
+			re := regexp.MustCompile(`#` + hashtag.Text + `( |$)`)
+			tweet.FullText = re.ReplaceAllString(tweet.FullText, `<a class="text-teal-500" href="https://twitter.com/hashtag/`+hashtag.Text+`" target="_blank" rel="noreferrer">#`+hashtag.Text+`</a> `)
+		}
+		for _, mention := range tweet.Entities.UserMentions {
+			re := regexp.MustCompile(`@` + mention.ScreenName + `( |$)`)
+			tweet.FullText = re.ReplaceAllString(tweet.FullText, `<a class="text-teal-600" href="https://twitter.com/`+mention.ScreenName+`" target="_blank" rel="noreferrer">@`+mention.ScreenName+`</a> `)
+		}
+		for _, _url := range tweet.Entities.Urls {
+			tweet.FullText = strings.ReplaceAll(tweet.FullText, _url.Url, `<a class="text-yellow-600" href="`+_url.ExpandedUrl+`" target="_blank" rel="noreferrer">`+_url.ExpandedUrl+`</a>`)
+		}
+		for _, _url := range tweet.Entities.Media {
+			tweet.FullText = strings.ReplaceAll(tweet.FullText, _url.Url, ``)
+		}
+
+		thread[tweetId] = &ThreadItem{
+			Tweet: tweet,
+			User:  user,
+		}
+	}
+
+	return map[string]interface{}{
+		"State":      state,
+		"Title":      title,
+		"Thread":     thread,
+		"Tweet":      cache.Tweet,
+		"User":       cache.User,
+		"TweetIndex": cache.Index,
+	}
+}
@@ -1,18 +1,18 @@
 package server
 
 import (
+	"context"
 	"embed"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/microcosm-cc/bluemonday"
+	"github.com/gorilla/websocket"
 	"html/template"
 	"io/fs"
+	"net"
 	"net/http"
 	"os"
-	"path"
 	"path/filepath"
-	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -22,20 +22,26 @@ import (
 )
 
 type Server struct {
-	Host string `json:"host"`
-	Port uint   `json:"port"`
-
-	websocketHub *WebsocketHub
-	assets       embed.FS
-	template     *template.Template
-	mediaDir     string
-	state        map[string]interface{}
-	mx           sync.RWMutex
-}
-
-type ThreadItem struct {
-	Tweet scraper.TweetResult
-	User  scraper.ConversationUser
+	Host string      `json:"host"`
+	Port uint        `json:"port"`
+	Auth AuthOptions `json:"auth"`
+	TLS  TLSOptions  `json:"tls"`
+
+	websocketHub   *WebsocketHub
+	hostNames      []string
+	upgrader       websocket.Upgrader
+	mux            *http.ServeMux
+	httpServer     *http.Server
+	redirectServer *http.Server
+	assets         embed.FS
+	template       *template.Template
+	mediaDir       string
+	state          map[string]interface{}
+	mx             sync.RWMutex
+
+	OnLoadTweet  func(id string) (*scraper.CachedTweet, error) `json:"-"`
+	OnApiRequest ApiHandler                                    `json:"-"`
+	OnExport     ExportHandler                                 `json:"-"`
 }
 
 func NewServer(mcb func(message *Message), assets embed.FS) *Server {
@@ -43,10 +49,16 @@ func NewServer(mcb func(message *Message), assets embed.FS) *Server {
 		Host:         "localhost",
 		Port:         4788,
 		websocketHub: NewWebsocketHub(),
+		mux:          http.NewServeMux(),
 		assets:       assets,
 		state:        map[string]interface{}{},
 	}
 	a.websocketHub.onReceive = mcb
+	a.upgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     a.checkOrigin,
+	}
 
 	return a
 }
@@ -54,6 +66,9 @@ func NewServer(mcb func(message *Message), assets embed.FS) *Server {
 func (s *Server) Load(mediaDir string) {
 	s.mediaDir = mediaDir
 	s.setRoutes()
+
+	// Start the hub right away, so events can be published before the server starts listening
+	go s.websocketHub.run()
 }
 
 func (s *Server) SetState(state map[string]interface{}) {
@@ -70,10 +85,6 @@ func (s *Server) AddState(key string, value interface{}) {
 	s.state[key] = value
 }
 
-func (s *Server) renderHtml(str string) template.HTML {
-	return template.HTML(str)
-}
-
 func (s *Server) GetState() map[string]interface{} {
 	s.mx.RLock()
 	defer s.mx.RUnlock()
@@ -87,15 +98,8 @@ func (s *Server) setRoutes() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	templates, err := fs.Sub(staticFS, "static/template")
 
-	tmpl := template.New("")
-	tmpl.Funcs(template.FuncMap{
-		"html":     s.renderHtml,
-		"GetState": s.GetState,
-	})
-
-	tmpl, err = tmpl.ParseFS(templates, "*.tmpl")
+	tmpl, err := ParseTemplates(staticFS, s.GetState, serverUrls)
 	if err != nil {
 		log.Fatal(err)
 	} else {
@@ -103,19 +107,96 @@ func (s *Server) setRoutes() {
 	}
 
 	// Serve static files
-	http.Handle("/", http.FileServer(http.FS(htmlContent)))
-	http.HandleFunc("/ws", s.websocketEndpoint)
-	http.HandleFunc("/media/", s.mediaEndpoint)
-	http.HandleFunc("/video/", s.videoEndpoint)
-	http.HandleFunc("/state", s.stateEndpoint)
-	http.HandleFunc("/thread/", s.threadEndpoint)
+	s.mux.Handle("/", http.FileServer(http.FS(htmlContent)))
+	s.mux.HandleFunc("/login", s.loginEndpoint)
+	s.mux.HandleFunc("/logout", s.logoutEndpoint)
+	s.mux.HandleFunc("/ws", s.protect(s.websocketEndpoint))
+	s.mux.HandleFunc("/media/", s.protect(s.mediaEndpoint))
+	s.mux.HandleFunc("/video/", s.protect(s.videoEndpoint))
+	s.mux.HandleFunc("/state", s.protect(s.stateEndpoint))
+	s.mux.HandleFunc("/thread/", s.protect(s.threadEndpoint))
+	s.setApiRoutes()
 }
 
+//
+// Start
+// @Description: Serve all routes until Shutdown gets called
+// @receiver s *Server
+// @return error
 func (s *Server) Start() error {
-	log.Info("Listening on: http://%s", s.Address())
-	go s.websocketHub.run()
+	if s.Auth.Enabled() == false && s.isLoopback() == false {
+		log.Warning("Authentication is disabled while listening on %s. Anyone who can reach the server can read your bookmarks.", s.Host)
+	}
+	s.loadHostNames()
+
+	s.mx.Lock()
+	s.httpServer = &http.Server{
+		Addr:    s.Address(),
+		Handler: s.mux,
+	}
+	s.mx.Unlock()
+
+	var err error
+	if s.TLS.Enabled() {
+		if s.TLS.RedirectPort > 0 {
+			s.mx.Lock()
+			s.redirectServer = &http.Server{
+				Addr:    fmt.Sprintf("%s:%d", s.Host, s.TLS.RedirectPort),
+				Handler: s.redirectHandler(),
+			}
+			s.mx.Unlock()
+
+			log.Info("Redirecting http://%s to https", s.redirectServer.Addr)
+			go func() {
+				if err := s.redirectServer.ListenAndServe(); err != nil && errors.Is(err, http.ErrServerClosed) == false {
+					log.Error("Failed to start the https redirect: %s", err.Error())
+				}
+			}()
+		}
+		log.Info("Listening on: https://%s", s.Address())
+		err = s.httpServer.ListenAndServeTLS(s.TLS.CertFile, s.TLS.KeyFile)
+	} else {
+		log.Info("Listening on: http://%s", s.Address())
+		err = s.httpServer.ListenAndServe()
+	}
 
-	return http.ListenAndServe(s.Address(), nil)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+//
+// Shutdown
+// @Description: Close all websocket connections and gracefully stop the http server
+// @receiver s *Server
+// @param ctx context.Context limits how long to wait for pending requests
+// @return error
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.websocketHub.Close(ctx)
+
+	s.mx.RLock()
+	servers := []*http.Server{s.httpServer, s.redirectServer}
+	s.mx.RUnlock()
+
+	for _, server := range servers {
+		if server == nil {
+			continue
+		}
+		if e := server.Shutdown(ctx); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// isLoopback checks if the server is only reachable from the local machine
+func (s *Server) isLoopback() bool {
+	if s.Host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(s.Host)
+	return ip != nil && ip.IsLoopback()
 }
 
 func (s *Server) Address() string {
@@ -129,62 +210,15 @@ func (s *Server) Hub() *WebsocketHub {
 func (s *Server) threadEndpoint(w http.ResponseWriter, r *http.Request) {
 	_statusId, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/thread/"))
 	if _statusId > 0 {
-		filename := path.Join(path.Dir(s.mediaDir), fmt.Sprintf("%d.json", _statusId))
-		dat, err := os.ReadFile(filename)
+		cache, err := s.OnLoadTweet(fmt.Sprintf("%d", _statusId))
 		if err == nil {
-			cache := &scraper.CachedTweet{}
-			if err := json.Unmarshal(dat, cache); err == nil {
-				if tmpl := s.template.Lookup("thread"); tmpl != nil {
-					title := bluemonday.StripTagsPolicy().Sanitize(cache.Tweet.FullText)
-					if len(title) > 16 {
-						title = title[0:13] + "..."
-					}
-
-					thread := map[string]*ThreadItem{}
-
-					for tweetId, tweet := range cache.Conversation.GlobalObjects.Tweets {
-						user, ok := cache.Conversation.GlobalObjects.Users[tweet.UserIdStr]
-						if !ok {
-							user = scraper.ConversationUser{}
-						}
-
-						for _, hashtag := range tweet.Entities.Hashtags {
-							re := regexp.MustCompile(`#` + hashtag.Text + `( |$)`)
-							tweet.FullText = re.ReplaceAllString(tweet.FullText, `<a class="text-teal-500" href="https://twitter.com/hashtag/`+hashtag.Text+`" target="_blank" rel="noreferrer">#`+hashtag.Text+`</a> `)
-						}
-						for _, mention := range tweet.Entities.UserMentions {
-							re := regexp.MustCompile(`@` + mention.ScreenName + `( |$)`)
-							tweet.FullText = re.ReplaceAllString(tweet.FullText, `<a class="text-teal-600" href="https://twitter.com/`+mention.ScreenName+`" target="_blank" rel="noreferrer">@`+mention.ScreenName+`</a> `)
-						}
-						for _, _url := range tweet.Entities.Urls {
-							tweet.FullText = strings.ReplaceAll(tweet.FullText, _url.Url, `<a class="text-yellow-600" href="`+_url.ExpandedUrl+`" target="_blank" rel="noreferrer">`+_url.ExpandedUrl+`</a>`)
-						}
-						for _, _url := range tweet.Entities.Media {
-							tweet.FullText = strings.ReplaceAll(tweet.FullText, _url.Url, ``)
-						}
-
-						thread[tweetId] = &ThreadItem{
-							Tweet: tweet,
-							User:  user,
-						}
-					}
-
-					if err := tmpl.Execute(w, map[string]interface{}{
-						"State":      s.state,
-						"Title":      title,
-						"Thread":     thread,
-						"Tweet":      cache.Tweet,
-						"User":       cache.User,
-						"TweetIndex": cache.Index,
-					}); err != nil {
-						log.Error("Failed to serve tweet %d: %s", _statusId, err.Error())
-					}
-					return
-				} else {
-					log.Error("Template not found")
+			if tmpl := s.template.Lookup("thread"); tmpl != nil {
+				if err := tmpl.Execute(w, ThreadData(cache, s.GetState())); err != nil {
+					log.Error("Failed to serve tweet %d: %s", _statusId, err.Error())
 				}
+				return
 			} else {
-				log.Error("Failed to serve tweet %d: %s", _statusId, err.Error())
+				log.Error("Template not found")
 			}
 		} else {
 			log.Error("Failed to serve tweet %d: %s", _statusId, err.Error())
@@ -291,14 +325,22 @@ func (s *Server) mediaEndpoint(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) websocketEndpoint(w http.ResponseWriter, r *http.Request) {
-	upgrader.CheckOrigin = func(r *http.Request) bool { return true }
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Error("Failed to upgrade websocket connection: %s", err.Error())
 		return
 	}
-	client := &WebsocketClient{hub: s.websocketHub, conn: conn, send: make(chan []byte, maxMessageSize)}
-	s.websocketHub.register <- client
+	client := &WebsocketClient{hub: s.websocketHub, conn: conn, send: make(chan []byte, maxMessageSize), subscriptions: map[string]bool{}}
+	s.websocketHub.pumps.Add(1)
+	select {
+	case s.websocketHub.register <- client:
+	case <-s.websocketHub.done:
+		// The server is shutting down
+		_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+		_ = conn.Close()
+		s.websocketHub.pumps.Done()
+		return
+	}
 
 	go client.writePump()
 	go client.readPump()
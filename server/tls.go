@@ -0,0 +1,183 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"tbm/utils/log"
+	"time"
+)
+
+const (
+	SelfSignedCertFile = "cert.pem"
+	SelfSignedKeyFile  = "key.pem"
+	SelfSignedValidity = 10 * 365 * 24 * time.Hour
+)
+
+// TLSOptions configure https. TLS is enabled as soon as a certificate and key are available.
+type TLSOptions struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	// SelfSigned generates a self-signed certificate inside the data dir if no certificate has been configured
+	SelfSigned bool `json:"self_signed"`
+	// RedirectPort serves a plain http listener redirecting every request to https (0 = disabled)
+	RedirectPort uint `json:"redirect_port"`
+}
+
+//
+// Enabled
+// @Description: Check if a certificate and key have been configured
+// @receiver t *TLSOptions
+// @return bool
+func (t *TLSOptions) Enabled() bool {
+	return t.CertFile != "" && t.KeyFile != ""
+}
+
+//
+// HostNames
+// @Description: Get the DNS names the configured certificate is valid for
+// @receiver t *TLSOptions
+// @return []string
+// @return error
+func (t *TLSOptions) HostNames() ([]string, error) {
+	b, err := os.ReadFile(t.CertFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no certificate found in %s", t.CertFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return cert.DNSNames, nil
+}
+
+//
+// Prepare
+// @Description: Generate a self-signed certificate inside the given directory if required and not yet existing
+// @receiver t *TLSOptions
+// @param dir string
+// @param host string the host the server binds to
+// @return error
+func (t *TLSOptions) Prepare(dir string, host string) error {
+	if t.Enabled() || t.SelfSigned == false {
+		return nil
+	}
+	t.CertFile = path.Join(dir, SelfSignedCertFile)
+	t.KeyFile = path.Join(dir, SelfSignedKeyFile)
+
+	_, certErr := os.Stat(t.CertFile)
+	_, keyErr := os.Stat(t.KeyFile)
+	if certErr == nil && keyErr == nil {
+		return nil
+	}
+
+	log.Info("Generating a self-signed certificate: %s", t.CertFile)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	return generateCertificate(t.CertFile, t.KeyFile, host)
+}
+
+func generateCertificate(certFile, keyFile, host string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"tbm"}, CommonName: "tbm self-signed"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(SelfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	addHost := func(h string) {
+		if ip := net.ParseIP(h); ip != nil {
+			if ip.IsUnspecified() == false {
+				template.IPAddresses = append(template.IPAddresses, ip)
+			}
+		} else if h != "" {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	addHost("localhost")
+	addHost("127.0.0.1")
+	addHost("::1")
+	if hostname, err := os.Hostname(); err == nil {
+		addHost(hostname)
+	}
+	if host != "localhost" {
+		addHost(host)
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		// The server is reachable on every interface, so include all local addresses
+		if addresses, err := net.InterfaceAddrs(); err == nil {
+			for _, address := range addresses {
+				if n, ok := address.(*net.IPNet); ok && n.IP.IsLoopback() == false {
+					template.IPAddresses = append(template.IPAddresses, n.IP)
+				}
+			}
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := writePem(certFile, "CERTIFICATE", der, 0644); err != nil {
+		return err
+	}
+	return writePem(keyFile, "EC PRIVATE KEY", keyDer, 0600)
+}
+
+func writePem(filename, blockType string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if err := pem.Encode(f, &pem.Block{Type: blockType, Bytes: data}); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+//
+// redirectHandler
+// @Description: Redirect every plain http request to the https listener
+// @receiver s *Server
+// @return http.HandlerFunc
+func (s *Server) redirectHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		target := fmt.Sprintf("https://%s%s", net.JoinHostPort(host, fmt.Sprintf("%d", s.Port)), r.URL.RequestURI())
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	}
+}
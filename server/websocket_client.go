@@ -2,6 +2,7 @@ package server
 
 import (
 	"github.com/gorilla/websocket"
+	"sync"
 	"tbm/utils/log"
 	"time"
 )
@@ -20,11 +21,6 @@ const (
 	maxMessageSize = 2024
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-}
-
 // WebsocketClient is a middleman between the websocket connection and the hub.
 type WebsocketClient struct {
 	hub *WebsocketHub
@@ -34,6 +30,10 @@ type WebsocketClient struct {
 
 	// Buffered channel of outbound messages.
 	send chan []byte
+
+	// Events the client has subscribed to.
+	subscriptions map[string]bool
+	mx            sync.RWMutex
 }
 
 // readPump pumps messages from the websocket connection to the hub.
@@ -43,7 +43,10 @@ type WebsocketClient struct {
 // reads from this goroutine.
 func (c *WebsocketClient) readPump() {
 	defer func() {
-		c.hub.unregister <- c
+		select {
+		case c.hub.unregister <- c:
+		case <-c.hub.done:
+		}
 		_ = c.conn.Close()
 	}()
 	c.conn.SetReadLimit(maxMessageSize)
@@ -62,9 +65,13 @@ func (c *WebsocketClient) readPump() {
 			}
 			break
 		}
-		c.hub.receive <- &Message{
+		select {
+		case c.hub.receive <- &Message{
 			Content: message,
 			Client:  c,
+		}:
+		case <-c.hub.done:
+			return
 		}
 	}
 }
@@ -78,6 +85,59 @@ func (c *WebsocketClient) Send(msg []byte) {
 	c.send <- msg
 }
 
+//
+// Subscribe
+// @Description: Receive all future messages published for the given event
+// @receiver c *WebsocketClient
+// @param event string
+func (c *WebsocketClient) Subscribe(event string) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.subscriptions[event] = true
+}
+
+//
+// Unsubscribe
+// @Description: Stop receiving messages published for the given event
+// @receiver c *WebsocketClient
+// @param event string
+func (c *WebsocketClient) Unsubscribe(event string) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	delete(c.subscriptions, event)
+}
+
+//
+// Subscriptions
+// @Description: Get all events the client has subscribed to
+// @receiver c *WebsocketClient
+// @return []string
+func (c *WebsocketClient) Subscriptions() []string {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+
+	events := make([]string, 0, len(c.subscriptions))
+	for event := range c.subscriptions {
+		events = append(events, event)
+	}
+	return events
+}
+
+//
+// IsSubscribed
+// @Description: Check if the client has subscribed to a given event
+// @receiver c *WebsocketClient
+// @param event string
+// @return bool
+func (c *WebsocketClient) IsSubscribed(event string) bool {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+
+	return c.subscriptions[event]
+}
+
 // writePump pumps messages from the hub to the websocket connection.
 //
 // A goroutine running writePump is started for each connection. The
@@ -88,6 +148,7 @@ func (c *WebsocketClient) writePump() {
 	defer func() {
 		ticker.Stop()
 		_ = c.conn.Close()
+		c.hub.pumps.Done()
 	}()
 	for {
 		select {
@@ -97,7 +158,7 @@ func (c *WebsocketClient) writePump() {
 			}
 			if !ok {
 				// The hub closed the channel.
-				_ = c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				_ = c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
 				return
 			}
 
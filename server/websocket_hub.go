@@ -1,5 +1,10 @@
 package server
 
+import (
+	"context"
+	"sync"
+)
+
 // WebsocketHub maintains the set of active clients and broadcasts messages to the
 // clients.
 type WebsocketHub struct {
@@ -8,6 +13,7 @@ type WebsocketHub struct {
 
 	// Inbound messages from the clients.
 	broadcast chan []byte
+	publish   chan *Event
 	receive   chan *Message
 
 	// Register requests from the clients.
@@ -17,6 +23,13 @@ type WebsocketHub struct {
 	unregister chan *WebsocketClient
 
 	onReceive func(m *Message)
+
+	// Closed to stop the hub and once it has stopped.
+	quit chan struct{}
+	done chan struct{}
+
+	// Running write pumps, which have to send their close frame before shutting down.
+	pumps sync.WaitGroup
 }
 
 type Message struct {
@@ -24,6 +37,12 @@ type Message struct {
 	Client  *WebsocketClient
 }
 
+// Event is a message which only gets delivered to clients subscribed to its name
+type Event struct {
+	Name    string
+	Content []byte
+}
+
 //
 // NewWebsocketHub
 // @Description: Create a new WebsocketHub instance
@@ -31,10 +50,13 @@ type Message struct {
 func NewWebsocketHub() *WebsocketHub {
 	return &WebsocketHub{
 		broadcast:  make(chan []byte),
+		publish:    make(chan *Event),
 		receive:    make(chan *Message),
 		register:   make(chan *WebsocketClient),
 		unregister: make(chan *WebsocketClient),
 		clients:    make(map[*WebsocketClient]bool),
+		quit:       make(chan struct{}),
+		done:       make(chan struct{}),
 		onReceive: func(m *Message) {
 
 		},
@@ -59,22 +81,88 @@ func (h *WebsocketHub) run() {
 			h.onReceive(message)
 		case message := <-h.broadcast:
 			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
+				h.deliver(client, message)
+			}
+		case event := <-h.publish:
+			for client := range h.clients {
+				if client.IsSubscribed(event.Name) {
+					h.deliver(client, event.Content)
 				}
 			}
+		case <-h.quit:
+			// Closing the send channel makes the write pump send a close frame
+			for client := range h.clients {
+				delete(h.clients, client)
+				close(client.send)
+			}
+			close(h.done)
+			return
 		}
 	}
 }
 
+//
+// Close
+// @Description: Disconnect all clients with a close frame and stop the hub
+// @receiver h *WebsocketHub
+// @param ctx context.Context limits how long to wait for the clients
+// @return error
+func (h *WebsocketHub) Close(ctx context.Context) error {
+	select {
+	case <-h.quit:
+	default:
+		close(h.quit)
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		<-h.done
+		h.pumps.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 //
 // Broadcast
 // @Description: Broadcast a given message to all connected clients
 // @receiver h *WebsocketHub
 // @param message []byte
 func (h *WebsocketHub) Broadcast(message []byte) {
-	h.broadcast <- message
+	select {
+	case h.broadcast <- message:
+	case <-h.done:
+	}
+}
+
+//
+// Publish
+// @Description: Send a given message to all clients subscribed to the event
+// @receiver h *WebsocketHub
+// @param event string
+// @param message []byte
+func (h *WebsocketHub) Publish(event string, message []byte) {
+	select {
+	case h.publish <- &Event{
+		Name:    event,
+		Content: message,
+	}:
+	case <-h.done:
+	}
+}
+
+// deliver queues a message and drops the client if it can't keep up
+func (h *WebsocketHub) deliver(client *WebsocketClient, message []byte) {
+	select {
+	case client.send <- message:
+	default:
+		close(client.send)
+		delete(h.clients, client)
+	}
 }
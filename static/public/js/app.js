@@ -3,13 +3,32 @@
     const errorHolder = document.getElementById("error-holder");
     const tweetHolder = document.getElementById("tweet-holder");
     const searchHolder = document.getElementById("search-holder");
+    const searchInput = document.getElementById("search-input");
+    const searchScope = document.getElementById("search-scope");
     const counterHolder = document.getElementById("counter-holder");
+    const statusHolder = document.getElementById("status-holder");
     const loading = document.getElementById("loading");
+    const logoutHolder = document.getElementById("logout-holder");
 
-    fetch("/state").then(body => body.json()).then((resp => {
-        const socket = new WebSocket(`ws://${location.host}/ws`);
+    fetch("/state").then(body => {
+        if (body.status === 401) {
+            location.href = "/login";
+            throw new Error("authentication required");
+        }
+        return body.json();
+    }).then((resp => {
+        const socket = new WebSocket(`${location.protocol === "https:" ? "wss" : "ws"}://${location.host}/ws`);
         const mode = resp.mode
-        let counter = 0;
+
+        if (resp.auth === true) {
+            logoutHolder.classList.remove("hidden");
+        }
+        const pageSize = 50;
+        let total = 0;
+        let offset = 0;
+        let pending = false;
+        let request = null;
+        let requestId = 0;
 
         // Display a given error message
         const setError = (err) => {
@@ -17,12 +36,63 @@
         }
 
         const updateCounter = () => {
-            counterHolder.innerHTML = `<div class='py-2'>Tweets found: ${counter}</div>`
+            counterHolder.innerHTML = `<div class='py-2'>Tweets found: ${total}</div>`
+        }
+
+        // Display the request budget of the scraper
+        const updateRateLimit = (rateLimit) => {
+            const now = new Date();
+            const backoffUntil = new Date(rateLimit.backoff_until);
+            const reset = new Date(rateLimit.reset);
+            if (backoffUntil > now) {
+                statusHolder.innerHTML = `<div class='text-xs text-slate-400'>Twitter requests paused after ${rateLimit.failures} failure(s) until ${backoffUntil.toLocaleTimeString()}</div>`
+            } else if (rateLimit.remaining === 0 && reset > now) {
+                statusHolder.innerHTML = `<div class='text-xs text-slate-400'>Twitter rate limit reached, continuing at ${reset.toLocaleTimeString()}</div>`
+            } else if (rateLimit.limit >= 0) {
+                statusHolder.innerHTML = `<div class='text-xs text-slate-400'>Twitter requests remaining: ${rateLimit.remaining} / ${rateLimit.limit}</div>`
+            } else {
+                statusHolder.innerHTML = "";
+            }
+        }
+
+        // Send a command and return its request id
+        const send = (command, payload) => {
+            requestId++;
+            socket.send(JSON.stringify({
+                id: `${requestId}`,
+                version: 2,
+                command: command,
+                payload: payload
+            }));
+            return `${requestId}`;
+        }
+
+        // Request a page of tweets using the given command and payload
+        const load = (command, payload, nextOffset) => {
+            offset = nextOffset;
+            pending = true;
+            const id = send(command, {...payload, offset: offset, limit: pageSize, fields: ["index", "user", "tweet", "thread_length"]});
+            request = {id, command, payload};
+        }
+
+        // Load the next page once the user scrolled close to the bottom
+        const loadMore = () => {
+            if (pending || request === null || offset + pageSize >= total) {
+                return
+            }
+            if (window.innerHeight + window.scrollY >= document.body.offsetHeight - window.innerHeight) {
+                load(request.command, request.payload, offset + pageSize);
+            }
         }
 
-        // Display a new tweet in the first position
-        const addTweet = (user, tweet, conversation) => {
-            const threadLength = Object.keys(conversation.globalObjects.tweets).length;
+        // New tweets only belong on top of the unfiltered list sorted by creation date
+        const showsNewest = () => {
+            return request !== null && request.command === "get_tweets" &&
+                request.payload.sort === "created_at" && request.payload.order === "desc";
+        }
+
+        // Display a tweet either in the first or last position
+        const addTweet = (user, tweet, threadLength, prepend) => {
             let content = tweet.full_text;
             tweet.entities.hashtags.map(ht => {
                 content = content.replace(new RegExp(`#${ht.text}( |$)`), `<a class="text-teal-500" href="https://twitter.com/hashtag/${ht.text}" target="_blank" rel="noreferrer">#${ht.text}</a> `)
@@ -62,7 +132,7 @@
         ${content}
     </div>
     <div class="w-full">
-        ${conversation.globalObjects.tweets?.[tweet.id_str]?.extended_entities.media?.map(ht => {
+        ${tweet.extended_entities?.media?.map(ht => {
             let url = ht.type === "video" ? ht.video_info?.variants[ht.video_info.variants.length - 1]?.url : ht.media_url_https;
             
             if (mode === "offline") {
@@ -79,24 +149,34 @@
         ${tweetDate}
     </div>
 </div>`
-            tweetHolder.insertBefore(tdiv, tweetHolder.firstChild);
+            if (prepend) {
+                tweetHolder.insertBefore(tdiv, tweetHolder.firstChild);
+            } else {
+                tweetHolder.appendChild(tdiv);
+            }
         }
 
         // Register an event listener on the search input field
         searchHolder.addEventListener('change', function(e) {
-            socket.send(JSON.stringify({
-                command: "search_tweets",
-                payload: {
-                    query: e.target.value
-                }
-            }));
+            load("search_tweets", {
+                query: searchInput.value,
+                scope: searchScope.value
+            }, 0);
         }, false);
 
-        // Get all tweets if the websocket connection has been established and opened
+        // Load further tweets while scrolling down
+        window.addEventListener('scroll', loadMore, {passive: true});
+
+        // Subscribe to new tweets and get the newest tweets if the websocket connection has been established and opened
         socket.onopen = function(e) {
-            socket.send(JSON.stringify({
-                command: "get_tweets"
-            }));
+            send("subscribe", {
+                events: ["tweet.added", "scraper.error", "scraper.rate_limit"]
+            });
+            send("get_rate_limit", {});
+            load("get_tweets", {
+                sort: "created_at",
+                order: "desc"
+            }, 0);
         };
 
         // Check if the websocket got closed correctly
@@ -119,36 +199,62 @@
             loading.classList.add("hidden")
             try {
                 const response = JSON.parse(event.data);
-                const keys = Object.keys(response.data);
                 const data = response.data;
 
-                if (response.errors.length > 0) {
-                    setError(data.errors.join(", "));
-                    return
-                }
-
-                for (let i = 0; i < keys.length; i++) {
-                    switch (keys[i]) {
-                        case "tweet":
-                            counter++;
+                if (response.type === "event") {
+                    switch (response.event) {
+                        case "tweet.added":
+                            total++;
                             updateCounter();
-                            return addTweet(data.user, data.tweet, data.conversation)
-                        case "tweets":
-                            counter = 0;
-                            if (data["tweets"].length === 0) {
-                                return tweetHolder.innerHTML = "<div class='w-full text-center pt-8 pb-4'>Not tweets found..</div>";
+                            if (showsNewest() === false) {
+                                return
                             }
-                            tweetHolder.innerHTML = "";
-                            updateCounter();
-                            data["tweets"].map(tweet => {
-                                counter++;
-                                return addTweet(tweet.user, tweet.tweet, tweet.conversation)
-                            });
-                            return updateCounter();
+                            // The tweet moves all loaded tweets down by one
+                            offset++;
+                            return addTweet(data.user, data.tweet, Object.keys(data.conversation.globalObjects.tweets).length, true)
+                        case "scraper.error":
+                            return setError(data.error)
+                        case "scraper.rate_limit":
+                            return updateRateLimit(data.rate_limit)
                         default:
-                            console.log("response key not implemented:", keys[i])
+                            return console.log("event not implemented:", response.event)
+                    }
+                }
+
+                // Ignore outdated responses of previous searches
+                const current = request !== null && response.id === request.id;
+                if (current) {
+                    pending = false;
+                }
+
+                if (response.errors.length > 0) {
+                    setError(response.errors.join(", "));
+                    return
+                }
 
+                if (data["tweets"] !== undefined) {
+                    if (current === false) {
+                        return
+                    }
+                    total = data["total"];
+                    if (data["offset"] === 0) {
+                        tweetHolder.innerHTML = "";
+                        window.scrollTo(0, 0);
                     }
+                    updateCounter();
+                    if (total === 0) {
+                        return tweetHolder.innerHTML = "<div class='w-full text-center pt-8 pb-4'>Not tweets found..</div>";
+                    }
+                    data["tweets"].map(tweet => addTweet(tweet.user, tweet.tweet, tweet.thread_length, false));
+                    return loadMore();
+                }
+
+                if (data["rate_limit"] !== undefined) {
+                    return updateRateLimit(data.rate_limit)
+                }
+
+                if (data["subscriptions"] === undefined) {
+                    console.log("response not implemented:", Object.keys(data))
                 }
             }catch (e) {
                 console.log(e)
@@ -0,0 +1,106 @@
+// Static site search
+(function() {
+    const errorHolder = document.getElementById("error-holder");
+    const tweetHolder = document.getElementById("tweet-holder");
+    const searchInput = document.getElementById("search-input");
+    const counterHolder = document.getElementById("counter-holder");
+
+    const pageSize = 50;
+    const tweets = window.tbmSearchIndex ?? [];
+    let results = [];
+    let offset = 0;
+
+    // Display a given error message
+    const setError = (err) => {
+        errorHolder.innerHTML = `<div class='py-2 px-2 border-l-4 border-red-700'>An error occurred: ${err}</div>`
+    }
+
+    if (window.tbmSearchIndex === undefined) {
+        return setError("search-index.js not found")
+    }
+
+    // Escape a text before inserting it into the page
+    const escape = (text) => {
+        const div = document.createElement("div");
+        div.textContent = text;
+        return div.innerHTML;
+    }
+
+    // Every tweet is searchable by its text, author, name and hashtags
+    tweets.map(tweet => {
+        tweet.search = [tweet.text, tweet.user, tweet.name, ...tweet.hashtags.map(ht => `#${ht}`)].join(" ").toLowerCase();
+    });
+
+    // Match all terms of the query. "from:user" only matches tweets of the given author.
+    const search = (query) => {
+        const terms = query.toLowerCase().split(/\s+/).filter(term => term !== "");
+        return tweets.filter(tweet => terms.every(term => {
+            if (term.startsWith("from:")) {
+                return tweet.user.toLowerCase() === term.substring(5).replace(/^@/, "");
+            }
+            return tweet.search.includes(term);
+        }));
+    }
+
+    // Display a single tweet
+    const addTweet = (tweet) => {
+        const tdiv = document.createElement("div")
+        tdiv.classList.add("w-full", "md:w-2/6", "xl:w-1/4","py-2","px-2")
+
+        tdiv.innerHTML = `
+<div class="border border-solid border-1 border-slate-600 py-2 px-2 flex flex-wrap rounded">
+    <div class="w-auto pr-2">
+        <a href="https://twitter.com/${escape(tweet.user)}" target="_blank" rel="noreferrer">
+            <img class="rounded-full" src="${escape(tweet.avatar)}"  alt=""/>
+        </a>
+    </div>
+    <div class="grow">
+        <a href="https://twitter.com/${escape(tweet.user)}" class="break-words" target="_blank" rel="noreferrer">
+            <span>${escape(tweet.name)}</span>
+            <span class="text-xs text-slate-400">
+                <br />
+                @${escape(tweet.user)}
+            </span>
+        </a>
+    </div>
+    <div class="w-full pt-2 break-words">
+        ${escape(tweet.text)}
+    </div>
+    <div class="w-full">
+        ${tweet.media.map(url => `<a href="${escape(tweet.url)}"><img class="rounded pt-2" src="${escape(url)}" alt=""/></a>`).join(" ")}
+    </div>
+    <div class="w-full pt-2"><a href="${escape(tweet.url)}" class="text-teal-600">🧵 ${tweet.thread > 1 ? `thread (${tweet.thread})` : "tweet"}</a></div>
+    <div class="w-55/100 text-xs text-slate-400 pt-2" title="Tweet ID">
+        <a href="https://twitter.com/${escape(tweet.user)}/status/${tweet.id}" class="text-yellow-600" target="_blank" rel="noreferrer">🐦 ${tweet.id}</a>
+    </div>
+    <div class="w-45/100 text-xs text-right text-slate-400 pt-2">
+        ${escape(tweet.date)}
+    </div>
+</div>`
+        tweetHolder.appendChild(tdiv);
+    }
+
+    // Display the next page once the user scrolled close to the bottom
+    const loadMore = () => {
+        while (offset < results.length && window.innerHeight + window.scrollY >= document.body.offsetHeight - window.innerHeight) {
+            results.slice(offset, offset + pageSize).map(addTweet);
+            offset += pageSize;
+        }
+    }
+
+    // Display the results of the current search
+    const update = () => {
+        results = search(searchInput.value);
+        offset = 0;
+        tweetHolder.innerHTML = "";
+        counterHolder.innerHTML = `<div class='py-2'>Tweets found: ${results.length}</div>`
+        if (results.length === 0) {
+            return tweetHolder.innerHTML = "<div class='w-full text-center pt-8 pb-4'>Not tweets found..</div>";
+        }
+        loadMore();
+    }
+
+    searchInput.addEventListener('input', update, false);
+    window.addEventListener('scroll', loadMore, {passive: true});
+    update();
+})();
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"tbm/scraper"
+	"tbm/utils/filesystem"
+)
+
+// JsonStorage keeps one json file per tweet inside a single directory
+type JsonStorage struct {
+	dir string
+}
+
+func NewJsonStorage(dir string) *JsonStorage {
+	return &JsonStorage{
+		dir: dir,
+	}
+}
+
+func (s *JsonStorage) filename(id string) string {
+	return path.Join(s.dir, id+".json")
+}
+
+func (s *JsonStorage) All() ([]*scraper.CachedTweet, error) {
+	items, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	tweets := make([]*scraper.CachedTweet, 0)
+	for _, item := range items {
+		if item.IsDir() || strings.HasSuffix(item.Name(), ".json") == false {
+			continue
+		}
+		dat, err := os.ReadFile(path.Join(s.dir, item.Name()))
+		if err != nil {
+			continue
+		}
+		ct := &scraper.CachedTweet{}
+		if err := json.Unmarshal(dat, ct); err == nil && ct.Tweet.IdStr != "" {
+			tweets = append(tweets, ct)
+		}
+	}
+
+	return tweets, nil
+}
+
+func (s *JsonStorage) Get(id string) (*scraper.CachedTweet, error) {
+	dat, err := os.ReadFile(s.filename(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	ct := &scraper.CachedTweet{}
+	if err := json.Unmarshal(dat, ct); err != nil {
+		return nil, err
+	}
+	return ct, nil
+}
+
+func (s *JsonStorage) Has(id string) bool {
+	return filesystem.Exist(s.filename(id))
+}
+
+func (s *JsonStorage) Save(ct *scraper.CachedTweet) error {
+	d, err := json.Marshal(ct)
+	if err != nil {
+		return err
+	}
+
+	// Write into a temporary file first, so an interrupted write never leaves a broken tweet behind
+	filename := s.filename(ct.Tweet.IdStr)
+	if err := os.WriteFile(filename+".tmp", d, 0644); err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+func (s *JsonStorage) Count() (int, error) {
+	items, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, item := range items {
+		if item.IsDir() == false && strings.HasSuffix(item.Name(), ".json") {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *JsonStorage) Close() error {
+	return nil
+}
@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"tbm/scraper"
+
+	_ "modernc.org/sqlite"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS users (
+	id          TEXT PRIMARY KEY,
+	screen_name TEXT NOT NULL DEFAULT '',
+	name        TEXT NOT NULL DEFAULT '',
+	data        TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS tweets (
+	id             TEXT PRIMARY KEY,
+	bookmark_index INTEGER NOT NULL DEFAULT 0,
+	user_id        TEXT NOT NULL DEFAULT '',
+	created_at     INTEGER NOT NULL DEFAULT 0,
+	data           TEXT NOT NULL,
+	timeline       TEXT NOT NULL DEFAULT '{}',
+	source         TEXT NOT NULL DEFAULT '',
+	user_data      TEXT NOT NULL DEFAULT '{}'
+);
+CREATE INDEX IF NOT EXISTS tweets_user_id ON tweets (user_id);
+CREATE TABLE IF NOT EXISTS conversation_tweets (
+	bookmark_id TEXT NOT NULL,
+	id          TEXT NOT NULL,
+	user_id     TEXT NOT NULL DEFAULT '',
+	data        TEXT NOT NULL,
+	PRIMARY KEY (bookmark_id, id)
+);
+CREATE TABLE IF NOT EXISTS conversation_users (
+	bookmark_id TEXT NOT NULL,
+	id          TEXT NOT NULL,
+	data        TEXT NOT NULL,
+	PRIMARY KEY (bookmark_id, id)
+);
+CREATE TABLE IF NOT EXISTS media (
+	id          TEXT NOT NULL,
+	bookmark_id TEXT NOT NULL,
+	tweet_id    TEXT NOT NULL,
+	type        TEXT NOT NULL DEFAULT '',
+	url         TEXT NOT NULL DEFAULT '',
+	alt_text    TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (bookmark_id, id)
+);
+`
+
+// selectTweets joins every tweet with its author. Authors without an id (e.g. of archived likes) are kept inside the
+// tweet row instead of the users table.
+const selectTweets = `SELECT t.bookmark_index, t.source, t.data, t.timeline, COALESCE(u.data, t.user_data) FROM tweets t LEFT JOIN users u ON u.id = t.user_id AND t.user_id != ''`
+
+// SqliteStorage keeps all tweets inside a single embedded sqlite database
+type SqliteStorage struct {
+	db *sql.DB
+}
+
+func NewSqliteStorage(filename string) (*SqliteStorage, error) {
+	db, err := sql.Open("sqlite", "file:"+filename+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	// sqlite only supports a single writer
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(schema); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &SqliteStorage{
+		db: db,
+	}, nil
+}
+
+func (s *SqliteStorage) All() ([]*scraper.CachedTweet, error) {
+	rows, err := s.db.Query(selectTweets + ` ORDER BY t.bookmark_index`)
+	if err != nil {
+		return nil, err
+	}
+
+	tweets := make([]*scraper.CachedTweet, 0)
+	lookup := map[string]*scraper.CachedTweet{}
+	for rows.Next() {
+		ct, err := scanTweet(rows)
+		if err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		tweets = append(tweets, ct)
+		lookup[ct.Tweet.IdStr] = ct
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := s.loadConversations("", func(id string) *scraper.CachedTweet {
+		return lookup[id]
+	}); err != nil {
+		return nil, err
+	}
+
+	return tweets, nil
+}
+
+func (s *SqliteStorage) Get(id string) (*scraper.CachedTweet, error) {
+	row := s.db.QueryRow(selectTweets+` WHERE t.id = ?`, id)
+	ct, err := scanTweet(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	if err := s.loadConversations(id, func(string) *scraper.CachedTweet {
+		return ct
+	}); err != nil {
+		return nil, err
+	}
+
+	return ct, nil
+}
+
+func (s *SqliteStorage) Has(id string) bool {
+	found := 0
+	_ = s.db.QueryRow(`SELECT COUNT(*) FROM tweets WHERE id = ?`, id).Scan(&found)
+	return found > 0
+}
+
+func (s *SqliteStorage) Save(ct *scraper.CachedTweet) error {
+	id := ct.Tweet.IdStr
+
+	tweet, err := json.Marshal(ct.Tweet)
+	if err != nil {
+		return err
+	}
+	user, err := json.Marshal(ct.User)
+	if err != nil {
+		return err
+	}
+	timeline, err := json.Marshal(ct.Conversation.Timeline)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// Users without an id would all share the same row, their data is stored with the tweet instead
+	userData := "{}"
+	if ct.User.RestId == "" {
+		userData = string(user)
+	} else if _, err := tx.Exec(
+		`INSERT OR REPLACE INTO users (id, screen_name, name, data) VALUES (?, ?, ?, ?)`,
+		ct.User.RestId, ct.User.Legacy.ScreenName, ct.User.Legacy.Name, string(user),
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		`INSERT OR REPLACE INTO tweets (id, bookmark_index, user_id, created_at, data, timeline, source, user_data) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, ct.Index, ct.User.RestId, scraper.ParseTime(ct.Tweet.CreatedAt).Unix(), string(tweet), string(timeline), ct.Source, userData,
+	); err != nil {
+		return err
+	}
+
+	for _, table := range []string{"conversation_tweets", "conversation_users", "media"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE bookmark_id = ?`, id); err != nil {
+			return err
+		}
+	}
+
+	for key, t := range ct.Conversation.GlobalObjects.Tweets {
+		data, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(
+			`INSERT INTO conversation_tweets (bookmark_id, id, user_id, data) VALUES (?, ?, ?, ?)`,
+			id, key, t.UserIdStr, string(data),
+		); err != nil {
+			return err
+		}
+
+		for _, m := range t.ExtendedEntities.Media {
+			if _, err := tx.Exec(
+				`INSERT OR REPLACE INTO media (id, bookmark_id, tweet_id, type, url, alt_text) VALUES (?, ?, ?, ?, ?, ?)`,
+				m.IdStr, id, key, m.Type, m.MediaUrlHttps, m.ExtAltText,
+			); err != nil {
+				return err
+			}
+		}
+	}
+
+	for key, u := range ct.Conversation.GlobalObjects.Users {
+		data, err := json.Marshal(u)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(
+			`INSERT INTO conversation_users (bookmark_id, id, data) VALUES (?, ?, ?)`,
+			id, key, string(data),
+		); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SqliteStorage) Count() (int, error) {
+	count := 0
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM tweets`).Scan(&count)
+	return count, err
+}
+
+func (s *SqliteStorage) Close() error {
+	return s.db.Close()
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanTweet(row scanner) (*scraper.CachedTweet, error) {
+	var tweet, timeline, user string
+	ct := &scraper.CachedTweet{}
+	if err := row.Scan(&ct.Index, &ct.Source, &tweet, &timeline, &user); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(tweet), &ct.Tweet); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(user), &ct.User); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(timeline), &ct.Conversation.Timeline); err != nil {
+		return nil, err
+	}
+	ct.Conversation.GlobalObjects.Tweets = map[string]scraper.TweetResult{}
+	ct.Conversation.GlobalObjects.Users = map[string]scraper.ConversationUser{}
+
+	return ct, nil
+}
+
+// loadConversations attaches all conversation tweets and users to their bookmark. An empty id loads every conversation.
+func (s *SqliteStorage) loadConversations(id string, resolve func(id string) *scraper.CachedTweet) error {
+	where, args := "", []interface{}{}
+	if id != "" {
+		where, args = " WHERE bookmark_id = ?", append(args, id)
+	}
+
+	rows, err := s.db.Query(`SELECT bookmark_id, id, data FROM conversation_tweets`+where, args...)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var bookmarkId, key, data string
+		if err := rows.Scan(&bookmarkId, &key, &data); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		if ct := resolve(bookmarkId); ct != nil {
+			t := scraper.TweetResult{}
+			if err := json.Unmarshal([]byte(data), &t); err != nil {
+				_ = rows.Close()
+				return err
+			}
+			ct.Conversation.GlobalObjects.Tweets[key] = t
+		}
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = s.db.Query(`SELECT bookmark_id, id, data FROM conversation_users`+where, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var bookmarkId, key, data string
+		if err := rows.Scan(&bookmarkId, &key, &data); err != nil {
+			return err
+		}
+		if ct := resolve(bookmarkId); ct != nil {
+			u := scraper.ConversationUser{}
+			if err := json.Unmarshal([]byte(data), &u); err != nil {
+				return err
+			}
+			ct.Conversation.GlobalObjects.Users[key] = u
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"tbm/scraper"
+)
+
+const (
+	JsonDriver   = "json"
+	SqliteDriver = "sqlite"
+
+	DatabaseFile = "tbm.db"
+	// MigrationFile is created inside the data dir once all json files have been migrated into another storage
+	MigrationFile = "json-migration.done"
+)
+
+var ErrNotFound = errors.New("tweet not found")
+
+// Storage persists fetched bookmarks
+type Storage interface {
+	// All returns every stored tweet
+	All() ([]*scraper.CachedTweet, error)
+	// Get returns a single tweet by its id or ErrNotFound
+	Get(id string) (*scraper.CachedTweet, error)
+	// Has checks whether a tweet with the given id has already been stored
+	Has(id string) bool
+	// Save creates or replaces the given tweet
+	Save(ct *scraper.CachedTweet) error
+	// Count returns the number of stored tweets
+	Count() (int, error)
+	Close() error
+}
+
+//
+// Open
+// @Description: Open the storage backend identified by driver inside the given data directory
+// @param driver string
+// @param dataDir string
+// @return Storage
+// @return error
+func Open(driver, dataDir string) (Storage, error) {
+	switch driver {
+	case JsonDriver:
+		return NewJsonStorage(dataDir), nil
+	case SqliteDriver, "":
+		return NewSqliteStorage(path.Join(dataDir, DatabaseFile))
+	}
+	return nil, fmt.Errorf("unknown storage driver \"%s\"", driver)
+}
+
+//
+// Migrate
+// @Description: Copy every tweet from src into dst. Tweets already present in dst are skipped.
+// @param src Storage
+// @param dst Storage
+// @return int number of imported tweets
+// @return error
+func Migrate(src, dst Storage) (int, error) {
+	tweets, err := src.All()
+	if err != nil {
+		return 0, err
+	}
+
+	imported := 0
+	for _, ct := range tweets {
+		if dst.Has(ct.Tweet.IdStr) {
+			continue
+		}
+		if err := dst.Save(ct); err != nil {
+			return imported, fmt.Errorf("failed to import tweet %s: %w", ct.Tweet.IdStr, err)
+		}
+		imported++
+	}
+
+	return imported, nil
+}
@@ -11,6 +11,7 @@ module.exports = {
     content: [
       './static/assets/**/*.{html,js}',
       './static/public/**/*.{html,js}',
+      './static/site/**/*.js',
       './static/template/*.tmpl'
     ],
     theme: {